@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+// assetManifest は静的ファイルのパスとMD5の対応
+type assetManifest map[string]string
+
+// webapp/public が見つからないときは初期状態の webapp/public と同じものを使う
+func defaultAssetManifest() assetManifest {
+	return assetManifest{
+		"/favicon.ico":         "ad4b0f606e0f8465bc4c4c170b37e1a3",
+		"/js/timeago.min.js":   "f2d4c53400d0a46de704f5a97d6d04fb",
+		"/js/main.js":          "9c309fed7e360c57a705978dab2c68ad",
+		"/css/style.css":       "e4c3606a18d11863189405eb5c6ca551",
+		"/img/ajax-loader.gif": "2a6692973429d7a74513bfa8bcb5be20",
+	}
+}
+
+var manifest = defaultAssetManifest()
+
+// newAssetManifest は起動時に静的ファイルから manifest を作る
+// dir が空なら userdata と同じリポジトリにある webapp/public を読む
+// そこに無ければ defaultAssetManifest を使う。dir を指定したときに読めなければエラーにする
+func newAssetManifest(dir, userdata string) (assetManifest, error) {
+	if dir != "" {
+		return loadAssetManifest(dir)
+	}
+	m, err := loadAssetManifest(filepath.Join(userdata, "..", "..", "webapp", "public"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return defaultAssetManifest(), nil
+	}
+	return m, err
+}
+
+// loadAssetManifest は dir 以下のファイルすべてのMD5を計算する
+func loadAssetManifest(dir string) (assetManifest, error) {
+	m := assetManifest{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		m["/"+filepath.ToSlash(rel)] = util.GetMD5(data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// asset は manifest に無いパスなら nil を返す
+func (m assetManifest) asset(p string) *checker.Asset {
+	md5, ok := m[p]
+	if !ok {
+		return nil
+	}
+	return &checker.Asset{Path: p, MD5: md5}
+}
+
+// extractAssets はページが読み込む静的ファイルのパスを返す
+// 投稿画像は loadImages で読み込むので含めない。外部のホストのものも含めない
+func extractAssets(doc *goquery.Document) []string {
+	var assets []string
+	add := func(ref string) {
+		p, ok := assetPath(ref)
+		if ok && !slices.Contains(assets, p) {
+			assets = append(assets, p)
+		}
+	}
+
+	for _, el := range doc.Find("link[href]").EachIter() {
+		rel := strings.Fields(strings.ToLower(el.AttrOr("rel", "")))
+		if slices.Contains(rel, "stylesheet") || slices.Contains(rel, "icon") || slices.Contains(rel, "preload") {
+			add(el.AttrOr("href", ""))
+		}
+	}
+	for _, el := range doc.Find("script[src]").EachIter() {
+		add(el.AttrOr("src", ""))
+	}
+	for _, el := range doc.Find("img[src]").Not(".isu-image").EachIter() {
+		add(el.AttrOr("src", ""))
+	}
+
+	return assets
+}
+
+func assetPath(ref string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", false
+	}
+	p := u.Path
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	p = path.Clean(p)
+	if u.RawQuery != "" {
+		p += "?" + u.RawQuery
+	}
+	return p, true
+}
+
+// 普通のページに表示されるべき静的ファイルに一通りアクセス
+// ブラウザと同じく favicon は HTML に書かれていなくても読み込む
+func loadAssets(s *checker.Session, assetURLs []string) {
+	if !slices.Contains(assetURLs, "/favicon.ico") {
+		assetURLs = append([]string{"/favicon.ico"}, assetURLs...)
+	}
+
+	for _, p := range assetURLs {
+		name, _, _ := strings.Cut(p, "?")
+		a := checker.NewAssetAction(p, manifest.asset(name))
+		a.Description = path.Base(name) + "が読み込めること"
+		a.Play(s)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+func TestExtractAssets(t *testing.T) {
+	html := `<html><head>
+<link href="/css/style.abc123.css" rel="stylesheet">
+<link href="/css/style.abc123.css" rel="stylesheet">
+<link href="https://cdn.example.com/x.css" rel="stylesheet">
+<link href="/feed" rel="alternate">
+</head><body>
+<img src="/img/ajax-loader.gif">
+<img class="isu-image" src="/image/1.png">
+<script src="js/main.js?v=2"></script>
+<script>inline()</script>
+</body></html>`
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"/css/style.abc123.css", "/js/main.js?v=2", "/img/ajax-loader.gif"}
+	if got := extractAssets(doc); !slices.Equal(got, expected) {
+		t.Errorf("expected %v to eq %v", got, expected)
+	}
+}
+
+func TestAssetManifest(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "css"), 0755)
+	os.WriteFile(filepath.Join(dir, "css", "style.css"), []byte("body{}"), 0644)
+
+	m, err := loadAssetManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md5 := util.GetMD5([]byte("body{}"))
+
+	a := m.asset("/css/style.css")
+	if a.MD5 != md5 {
+		t.Errorf("expected %s to eq %s", a.MD5, md5)
+	}
+
+	// manifest に無いファイルは失敗にする
+	if a := m.asset("/css/style.abc123.css"); a != nil {
+		t.Errorf("expected %v to be nil", a)
+	}
+
+	// 初期状態の webapp が読み込むものはすべて含む
+	for _, p := range []string{"/favicon.ico", "/css/style.css", "/js/main.js", "/js/timeago.min.js", "/img/ajax-loader.gif"} {
+		if defaultAssetManifest().asset(p) == nil {
+			t.Errorf("expected %s to be in the default manifest", p)
+		}
+	}
+}
+
+func TestNewAssetManifest(t *testing.T) {
+	root := t.TempDir()
+	userdata := filepath.Join(root, "benchmarker", "userdata")
+	public := filepath.Join(root, "webapp", "public")
+	os.MkdirAll(userdata, 0755)
+	os.MkdirAll(public, 0755)
+	os.WriteFile(filepath.Join(public, "app.js"), []byte("main()"), 0644)
+
+	// 指定しなければ userdata と同じリポジトリの webapp/public から作る
+	m, err := newAssetManifest("", userdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.asset("/app.js") == nil || m.asset("/css/style.css") != nil {
+		t.Errorf("expected the manifest of %s: %v", public, m)
+	}
+
+	// 見つからなければ初期状態のものを使う
+	m, err = newAssetManifest("", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != len(defaultAssetManifest()) {
+		t.Errorf("expected %d to eq %d", len(m), len(defaultAssetManifest()))
+	}
+
+	if _, err := newAssetManifest(filepath.Join(root, "missing"), userdata); err == nil {
+		t.Error("expected an error for a missing -public-dir")
+	}
+
+	// 初期状態の manifest はこのリポジトリの webapp/public と同じ
+	m, err = newAssetManifest("", "userdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p, md5 := range defaultAssetManifest() {
+		if m[p] != md5 {
+			t.Errorf("expected %s to eq %s for %s", m[p], md5, p)
+		}
+	}
+}
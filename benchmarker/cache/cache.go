@@ -2,6 +2,8 @@ package cache
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -9,86 +11,166 @@ import (
 	"github.com/marcw/cachecontrol"
 )
 
-type cacheStore struct {
+// CacheStore はブラウザのキャッシュと同じようにセッションごとに持つ
+type CacheStore struct {
 	sync.RWMutex
 	items map[string]*URLCache
 }
 
-func NewCacheStore() *cacheStore {
+func NewCacheStore() *CacheStore {
 	m := make(map[string]*URLCache)
-	c := &cacheStore{
+	c := &CacheStore{
 		items: m,
 	}
 	return c
 }
 
-func (c *cacheStore) Get(key string) (*URLCache, bool) {
+func (c *CacheStore) Get(key string) (*URLCache, bool) {
 	c.RLock()
 	v, found := c.items[key]
 	c.RUnlock()
 	return v, found
 }
 
-func (c *cacheStore) Set(key string, value *URLCache) {
+func (c *CacheStore) Set(key string, value *URLCache) {
 	c.Lock()
 	c.items[key] = value
 	c.Unlock()
 }
 
-var instance *cacheStore
-var once sync.Once
-
-func GetInstance() *cacheStore {
-	once.Do(func() {
-		instance = NewCacheStore()
-	})
-
-	return instance
-}
-
 type URLCache struct {
 	LastModified string
 	Etag         string
 	ExpiresAt    time.Time
+	NoCache      bool
 	CacheControl *cachecontrol.CacheControl
 	MD5          string
+
+	// Vary で指定されたリクエストヘッダーの値
+	Vary map[string]string
 }
 
-func NewURLCache(res *http.Response) (*URLCache, string) {
+// NewURLCache はレスポンスのボディのMD5と、キャッシュできるならその情報を返す
+// no-store のときと、有効期限も検証用のヘッダーも無いときはキャッシュしない
+func NewURLCache(req *http.Request, res *http.Response) (*URLCache, string) {
 	directive := res.Header.Get("Cache-Control")
 	cc := cachecontrol.Parse(directive)
-	noCache, _ := cc.NoCache()
 	md5 := util.GetMD5ByIO(res.Body)
 
-	if len(directive) == 0 || noCache || cc.NoStore() {
+	if cc.NoStore() {
 		return nil, md5
 	}
 
-	now := time.Now()
-	lm := res.Header.Get("Last-Modified")
-	etag := res.Header.Get("ETag")
+	vary, ok := varyValues(req, res)
+	if !ok {
+		return nil, md5
+	}
 
-	return &URLCache{
-		LastModified: lm,
-		Etag:         etag,
-		ExpiresAt:    now.Add(cc.MaxAge()),
+	noCache, _ := cc.NoCache()
+	c := &URLCache{
+		LastModified: res.Header.Get("Last-Modified"),
+		Etag:         res.Header.Get("ETag"),
+		NoCache:      noCache,
 		CacheControl: &cc,
 		MD5:          md5,
-	}, md5
+		Vary:         vary,
+	}
+	c.ExpiresAt = expiresAt(res, cc)
+
+	if !c.Fresh() && !c.HasValidators() {
+		return nil, md5
+	}
+
+	return c, md5
 }
 
-func (c *URLCache) Available() bool {
-	return time.Now().Before(c.ExpiresAt)
+// Cache-Control の max-age を Expires より優先する。Age の分だけ短くする
+func expiresAt(res *http.Response, cc cachecontrol.CacheControl) time.Time {
+	now := time.Now()
+
+	var age time.Duration
+	if a, err := strconv.Atoi(res.Header.Get("Age")); err == nil && a > 0 {
+		age = time.Duration(a) * time.Second
+	}
+
+	if strings.Contains(res.Header.Get("Cache-Control"), "max-age") {
+		return now.Add(cc.MaxAge() - age)
+	}
+
+	if expires := res.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			// 不正な値は期限切れ扱い
+			return now
+		}
+		if date, err := http.ParseTime(res.Header.Get("Date")); err == nil {
+			return now.Add(t.Sub(date) - age)
+		}
+		return t
+	}
+
+	return now
 }
 
-func (c *URLCache) Apply(req *http.Request) {
-	if c.Available() {
-		if c.LastModified != "" {
-			req.Header.Add("If-Modified-Since", c.LastModified)
+func varyValues(req *http.Request, res *http.Response) (map[string]string, bool) {
+	vary := map[string]string{}
+	for _, v := range res.Header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil, false
+			}
+			if name != "" {
+				vary[name] = req.Header.Get(name)
+			}
 		}
+	}
+	return vary, true
+}
 
-		if c.Etag != "" {
-			req.Header.Add("If-None-Match", c.Etag)
+// Fresh ならリクエストを送らずにキャッシュを使える
+func (c *URLCache) Fresh() bool {
+	return !c.NoCache && time.Now().Before(c.ExpiresAt)
+}
+
+func (c *URLCache) HasValidators() bool {
+	return c.Etag != "" || c.LastModified != ""
+}
+
+// Match は Vary で指定されたヘッダーがキャッシュしたときと同じか確認する
+func (c *URLCache) Match(req *http.Request) bool {
+	for name, value := range c.Vary {
+		if req.Header.Get(name) != value {
+			return false
 		}
 	}
+	return true
+}
+
+// Apply は期限切れのキャッシュを再検証するための条件付きリクエストにする
+func (c *URLCache) Apply(req *http.Request) {
+	if c.LastModified != "" {
+		req.Header.Add("If-Modified-Since", c.LastModified)
+	}
+
+	if c.Etag != "" {
+		req.Header.Add("If-None-Match", c.Etag)
+	}
+}
+
+// Refresh は304のレスポンスで有効期限と検証用のヘッダーを更新する
+func (c *URLCache) Refresh(res *http.Response) {
+	if res.Header.Get("Cache-Control") != "" || res.Header.Get("Expires") != "" {
+		cc := cachecontrol.Parse(res.Header.Get("Cache-Control"))
+		noCache, _ := cc.NoCache()
+		c.CacheControl = &cc
+		c.NoCache = noCache
+		c.ExpiresAt = expiresAt(res, cc)
+	}
+	if etag := res.Header.Get("ETag"); etag != "" {
+		c.Etag = etag
+	}
+	if lm := res.Header.Get("Last-Modified"); lm != "" {
+		c.LastModified = lm
+	}
 }
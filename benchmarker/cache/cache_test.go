@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newResponse(header map[string]string) *http.Response {
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+	}
+	for k, v := range header {
+		res.Header.Set(k, v)
+	}
+	return res
+}
+
+func TestNewURLCache(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/favicon.ico", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	uc, _ := NewURLCache(req, newResponse(map[string]string{"Cache-Control": "no-store", "ETag": `"a"`}))
+	if uc != nil {
+		t.Errorf("expected no-store not to be cached")
+	}
+
+	uc, _ = NewURLCache(req, newResponse(map[string]string{"Cache-Control": "max-age=60", "Age": "30"}))
+	if uc == nil || !uc.Fresh() {
+		t.Fatalf("expected max-age to be fresh")
+	}
+	if d := time.Until(uc.ExpiresAt); d > 30*time.Second {
+		t.Errorf("expected %s to be less than 30s", d)
+	}
+
+	uc, _ = NewURLCache(req, newResponse(map[string]string{"Cache-Control": "no-cache", "ETag": `"a"`}))
+	if uc == nil || uc.Fresh() {
+		t.Fatalf("expected no-cache to need revalidation")
+	}
+
+	expires := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	uc, _ = NewURLCache(req, newResponse(map[string]string{"Expires": expires, "Vary": "Accept-Encoding"}))
+	if uc == nil || !uc.Fresh() {
+		t.Fatalf("expected Expires to be fresh")
+	}
+	if !uc.Match(req) {
+		t.Errorf("expected the same request to match")
+	}
+	req2, _ := http.NewRequest("GET", "/favicon.ico", nil)
+	if uc.Match(req2) {
+		t.Errorf("expected a different Accept-Encoding not to match")
+	}
+
+	uc, _ = NewURLCache(req, newResponse(map[string]string{"Cache-Control": "max-age=60", "Vary": "*"}))
+	if uc != nil {
+		t.Errorf("expected Vary: * not to be cached")
+	}
+}
+
+func TestApply(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/favicon.ico", nil)
+	res := newResponse(map[string]string{"ETag": `"a"`, "Last-Modified": "Mon, 02 Jan 2006 15:04:05 GMT"})
+	uc, _ := NewURLCache(req, res)
+	if uc == nil {
+		t.Fatalf("expected validators to be cached")
+	}
+
+	uc.Apply(req)
+	if got := req.Header.Get("If-None-Match"); !strings.Contains(got, "a") {
+		t.Errorf("expected %s to eq %s", got, `"a"`)
+	}
+	if got := req.Header.Get("If-Modified-Since"); got == "" {
+		t.Errorf("expected If-Modified-Since to be set")
+	}
+}
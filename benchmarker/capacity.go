@@ -0,0 +1,79 @@
+package main
+
+import (
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/score"
+)
+
+const LoadModeAdaptive = "adaptive"
+
+type capacityOptions struct {
+	MaxFailRate float64
+	LatencySLO  time.Duration
+}
+
+// CapacityResult は閾値を超えずに捌けた一番高い並列数
+type CapacityResult struct {
+	Multiplier  float64 `json:"multiplier"`
+	Concurrency int     `json:"concurrency"`
+	Throughput  float64 `json:"throughput"`
+	P99         float64 `json:"p99"`
+	FailRate    float64 `json:"fail_rate"`
+	Saturated   bool    `json:"saturated"`
+}
+
+// runCapacitySearch は StageInterval ごとに並列数を StepMultiplier ずつ上げていき、
+// 失敗率かp99レイテンシが閾値を超えたところで止める
+func runCapacitySearch(pools []*scenarioPool, profile concurrencyProfile, total time.Duration, stageOpts loadStageOptions, opts capacityOptions) ([]StageResult, *CapacityResult) {
+	results := []StageResult{}
+	capacity := &CapacityResult{}
+	start := time.Now()
+
+	for i := 0; time.Since(start)+stageOpts.StageInterval <= total; i++ {
+		multiplier := 1 + float64(i)*stageOpts.StepMultiplier
+
+		before := takeScoreSnapshot()
+		beforeFails := score.GetFailErrorsInstance().Count()
+		beforeLatency := score.GetLatencyInstance().Total()
+		stageStart := time.Since(start)
+
+		concurrency := resizePools(pools, profile.Scale(multiplier))
+		time.Sleep(stageOpts.StageInterval)
+
+		after := takeScoreSnapshot()
+		success := after.successes - before.successes
+		fail := int64(score.GetFailErrorsInstance().Count() - beforeFails)
+		failRate := errorRate(success, fail)
+		p99 := score.GetLatencyInstance().Total().Sub(beforeLatency).Percentile(99)
+
+		results = append(results, StageResult{
+			Stage:       i + 1,
+			Start:       stageStart.Seconds(),
+			Duration:    stageOpts.StageInterval.Seconds(),
+			Multiplier:  multiplier,
+			Concurrency: concurrency,
+			Score:       after.score - before.score,
+			Success:     success,
+			Fail:        fail,
+			ErrorRate:   failRate,
+		})
+
+		if failRate > opts.MaxFailRate || time.Duration(p99)*time.Microsecond > opts.LatencySLO {
+			capacity.Saturated = true
+			break
+		}
+
+		*capacity = CapacityResult{
+			Multiplier:  multiplier,
+			Concurrency: concurrency,
+			Throughput:  float64(success) / stageOpts.StageInterval.Seconds(),
+			P99:         float64(p99) / 1000,
+			FailRate:    failRate,
+		}
+	}
+
+	stopPools(pools)
+
+	return results, capacity
+}
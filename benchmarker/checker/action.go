@@ -12,6 +12,7 @@ import (
 	"regexp"
 
 	"github.com/catatsuy/private-isu/benchmarker/cache"
+	"github.com/catatsuy/private-isu/benchmarker/score"
 )
 
 type Action struct {
@@ -43,6 +44,8 @@ type Asset struct {
 	Path string
 	MD5  string
 	Type string
+	// Image は -image-verify=decode か -image-accept を指定したときだけ使う
+	Image *ImageFingerprint
 }
 
 func NewAction(method, path string) *Action {
@@ -64,7 +67,7 @@ func (a *Action) Play(s *Session) error {
 
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return s.Fail(failExceptionScore, req, errors.New("リクエストに失敗しました (主催者に連絡してください)"))
+		return a.fail(s, failExceptionScore, nil, nil, score.CategoryInternal, errors.New("リクエストに失敗しました (主催者に連絡してください)"))
 	}
 
 	for key, val := range a.Headers {
@@ -78,24 +81,24 @@ func (a *Action) Play(s *Session) error {
 	res, err := s.SendRequest(req)
 
 	if err != nil {
-		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return s.Fail(failExceptionScore, req, errors.New("リクエストがタイムアウトしました"))
-		}
-		fmt.Fprintln(os.Stderr, err)
-		return s.Fail(failExceptionScore, req, errors.New("リクエストに失敗しました"))
+		category, err := requestFailure(err)
+		return a.fail(s, failExceptionScore, req, nil, category, err)
 	}
 
 	defer res.Body.Close()
 
 	if res.StatusCode != a.ExpectedStatusCode {
-		return s.Fail(failErrorScore, res.Request, fmt.Errorf("response code should be %d, got %d", a.ExpectedStatusCode, res.StatusCode))
+		return a.fail(s, failErrorScore, req, res, score.CategoryStatus, fmt.Errorf("response code should be %d, got %d", a.ExpectedStatusCode, res.StatusCode))
 	}
 
 	if a.ExpectedLocation != "" {
 		if !regexp.MustCompile(a.ExpectedLocation).MatchString(res.Request.URL.Path) {
-			return s.Fail(
+			return a.fail(
+				s,
 				failErrorScore,
-				res.Request,
+				req,
+				res,
+				score.CategoryRedirect,
 				fmt.Errorf(
 					"リダイレクト先URLが正しくありません: expected '%s', got '%s'",
 					a.ExpectedLocation, res.Request.URL.Path,
@@ -106,9 +109,12 @@ func (a *Action) Play(s *Session) error {
 	if a.CheckFunc != nil {
 		err := a.CheckFunc(res.Body)
 		if err != nil {
-			return s.Fail(
+			return a.fail(
+				s,
 				failErrorScore,
-				res.Request,
+				req,
+				res,
+				score.CategoryDOM,
 				err,
 			)
 		}
@@ -123,11 +129,51 @@ func (a *Action) Play(s *Session) error {
 	return nil
 }
 
+// fail は失敗を記録する。パスはリダイレクト後の最終的なリクエストのものを使う
+func (a *Action) fail(s *Session, point int64, req *http.Request, res *http.Response, category score.Category, err error) error {
+	f := &score.Failure{
+		Description:    a.Description,
+		Method:         a.Method,
+		Path:           a.Path,
+		ExpectedStatus: a.ExpectedStatusCode,
+		Category:       category,
+		Message:        err.Error(),
+	}
+	if res != nil {
+		f.Method = res.Request.Method
+		f.Path = res.Request.URL.Path
+		f.ActualStatus = res.StatusCode
+	} else if req != nil {
+		f.Method = req.Method
+		f.Path = req.URL.Path
+	}
+
+	if dumpDir != "" && req != nil {
+		dumpExchange(f, s.Scenario, req, res)
+	}
+
+	return s.Fail(point, f)
+}
+
+// requestFailure はリクエストを送れなかったときのエラーの種類を判別する
+func requestFailure(err error) (score.Category, error) {
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return score.CategoryTimeout, errors.New("リクエストがタイムアウトしました")
+	}
+	var encErr *encodingError
+	if errors.As(err, &encErr) {
+		return score.CategoryEncoding, fmt.Errorf("レスポンスの圧縮が正しくありません (%s)", encErr)
+	}
+	fmt.Fprintln(os.Stderr, err)
+	return score.CategoryConnection, errors.New("リクエストに失敗しました")
+}
+
 type AssetAction struct {
 	*Action
 	Asset *Asset
 }
 
+// asset が nil なら知らない静的ファイルとしてリクエストを送らずに失敗にする
 func NewAssetAction(path string, asset *Asset) *AssetAction {
 	return &AssetAction{
 		Asset: asset,
@@ -140,6 +186,10 @@ func NewAssetAction(path string, asset *Asset) *AssetAction {
 }
 
 func (a *AssetAction) Play(s *Session) error {
+	if a.Asset == nil {
+		return a.fail(s, failErrorScore, nil, nil, score.CategoryAsset, errors.New("存在しないはずの静的ファイルが読み込まれています"))
+	}
+
 	formData := url.Values{}
 	for key, val := range a.PostData {
 		formData.Set(key, val)
@@ -150,14 +200,26 @@ func (a *AssetAction) Play(s *Session) error {
 
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return s.Fail(failExceptionScore, req, errors.New("リクエストに失敗しました (主催者に連絡してください)"))
+		return a.fail(s, failExceptionScore, nil, nil, score.CategoryInternal, errors.New("リクエストに失敗しました (主催者に連絡してください)"))
 	}
 
 	for key, val := range a.Headers {
 		req.Header.Add(key, val)
 	}
 
-	urlCache, cacheFound := cache.GetInstance().Get(a.Path)
+	urlCache, cacheFound := s.Cache.Get(a.Path)
+	if cacheFound && !urlCache.Match(req) {
+		cacheFound = false
+	}
+
+	// 有効期限内ならブラウザと同じくリクエストを送らない
+	// キャッシュさせられたのはサーバーの手柄なので 304 と同じ点数を付ける
+	if cacheFound && urlCache.Fresh() {
+		score.GetCacheInstance().Hit()
+		s.Success(suceessGetScore)
+		return nil
+	}
+
 	if cacheFound {
 		urlCache.Apply(req)
 	}
@@ -165,46 +227,60 @@ func (a *AssetAction) Play(s *Session) error {
 	res, err := s.SendRequest(req)
 
 	if err != nil {
-		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return s.Fail(failExceptionScore, req, errors.New("リクエストがタイムアウトしました"))
-		}
-		fmt.Fprintln(os.Stderr, err)
-		return s.Fail(failExceptionScore, req, errors.New("リクエストに失敗しました"))
+		category, err := requestFailure(err)
+		return a.fail(s, failExceptionScore, req, nil, category, err)
 	}
 
-	// 2回io.ReadAllを呼ぶとおかしくなる
-	uc, md5 := cache.NewURLCache(res)
-	if uc != nil {
-		cache.GetInstance().Set(a.Path, uc)
-		if res.StatusCode == http.StatusOK && a.Asset.MD5 == "" {
-			a.Asset.MD5 = md5
-		}
-	} else if a.Asset.MD5 == "" {
-		a.Asset.MD5 = md5
-	}
-
-	success := false
+	defer res.Body.Close()
 
-	// キャッシュが有効でかつStatusNotModifiedのときは成功
+	// キャッシュがあってStatusNotModifiedのときは成功
 	if cacheFound && res.StatusCode == http.StatusNotModified {
-		success = true
+		urlCache.Refresh(res)
+		score.GetCacheInstance().Revalidated()
+		s.Success(suceessGetScore)
+		return nil
 	}
 
-	if res.StatusCode == http.StatusOK &&
-		((uc == nil && md5 == a.Asset.MD5) || uc != nil) {
-		success = true
+	decode := a.Asset.Image != nil && res.StatusCode == http.StatusOK
+	var data []byte
+	if decode {
+		data, err = io.ReadAll(res.Body)
+		if err != nil {
+			category, err := requestFailure(err)
+			return a.fail(s, failExceptionScore, req, res, category, err)
+		}
+		res.Body = &capturedBody{Reader: bytes.NewReader(data), data: data}
 	}
 
-	defer res.Body.Close()
+	// 2回io.ReadAllを呼ぶとおかしくなる
+	uc, md5 := cache.NewURLCache(req, res)
+	if res.StatusCode == http.StatusOK && a.Asset.MD5 == "" {
+		a.Asset.MD5 = md5
+	}
 
-	if !success {
-		return s.Fail(
+	if res.StatusCode != http.StatusOK || (!decode && md5 != a.Asset.MD5) {
+		return a.fail(
+			s,
 			failErrorScore,
-			res.Request,
+			req,
+			res,
+			score.CategoryAsset,
 			fmt.Errorf("静的ファイルが正しくありません"),
 		)
 	}
 
+	// MD5が違っても再圧縮したり別の形式にしただけなら成功にする
+	if decode && md5 != a.Asset.MD5 {
+		err := verifyImage(a.Asset, data)
+		if err != nil {
+			return a.fail(s, failErrorScore, req, res, score.CategoryAsset, err)
+		}
+	}
+
+	if uc != nil {
+		s.Cache.Set(a.Path, uc)
+	}
+	score.GetCacheInstance().Miss()
 	s.Success(suceessGetScore)
 
 	return nil
@@ -232,7 +308,7 @@ func (a *UploadAction) Play(s *Session) error {
 
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return s.Fail(failExceptionScore, req, errors.New("リクエストに失敗しました (主催者に連絡してください)"))
+		return a.fail(s, failExceptionScore, nil, nil, score.CategoryInternal, errors.New("リクエストに失敗しました (主催者に連絡してください)"))
 	}
 
 	for key, val := range a.Headers {
@@ -242,28 +318,31 @@ func (a *UploadAction) Play(s *Session) error {
 	res, err := s.SendRequest(req)
 
 	if err != nil {
-		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return s.Fail(failExceptionScore, req, errors.New("リクエストがタイムアウトしました"))
-		}
-		fmt.Fprintln(os.Stderr, err)
-		return s.Fail(failExceptionScore, req, errors.New("リクエストに失敗しました"))
+		category, err := requestFailure(err)
+		return a.fail(s, failExceptionScore, req, nil, category, err)
 	}
 
 	defer res.Body.Close()
 
 	if res.StatusCode != a.ExpectedStatusCode {
-		return s.Fail(
+		return a.fail(
+			s,
 			failErrorScore,
-			res.Request,
+			req,
+			res,
+			score.CategoryStatus,
 			fmt.Errorf("ステータスコードが正しくありません: expected %d, got %d", a.ExpectedStatusCode, res.StatusCode),
 		)
 	}
 
 	if a.ExpectedLocation != "" {
 		if !regexp.MustCompile(a.ExpectedLocation).MatchString(res.Request.URL.Path) {
-			return s.Fail(
+			return a.fail(
+				s,
 				failErrorScore,
-				res.Request,
+				req,
+				res,
+				score.CategoryRedirect,
 				fmt.Errorf(
 					"リダイレクト先URLが正しくありません: expected '%s', got '%s'",
 					a.ExpectedLocation, res.Request.URL.Path,
@@ -274,9 +353,12 @@ func (a *UploadAction) Play(s *Session) error {
 	if a.CheckFunc != nil {
 		err := a.CheckFunc(res.Body)
 		if err != nil {
-			return s.Fail(
+			return a.fail(
+				s,
 				failErrorScore,
-				res.Request,
+				req,
+				res,
+				score.CategoryDOM,
 				err,
 			)
 		}
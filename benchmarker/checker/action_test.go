@@ -0,0 +1,45 @@
+package checker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/catatsuy/private-isu/benchmarker/score"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+func TestAssetActionFreshCacheHit(t *testing.T) {
+	defer SetTargetHost("http://localhost")
+
+	body := []byte("body { color: red; }")
+	var requests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	if _, err := SetTargetHost(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSession()
+	asset := &Asset{Path: "/css/style.css", MD5: util.GetMD5(body)}
+	before := score.GetInstance().GetRawScore()
+	for range 2 {
+		if err := NewAssetAction(asset.Path, asset).Play(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if requests.Load() != 1 {
+		t.Errorf("expected %d to eq %d", requests.Load(), 1)
+	}
+	// 2回目はリクエストを送らないが 304 と同じく点数が付く
+	if got := score.GetInstance().GetRawScore() - before; got != 2*suceessGetScore {
+		t.Errorf("expected %d to eq %d", got, 2*suceessGetScore)
+	}
+}
@@ -0,0 +1,57 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// UnixSocketHost は Unix ソケットのターゲットに送る Host ヘッダー
+// cookie もこのホストのものとして扱う
+const UnixSocketHost = "localhost"
+
+// resolveOverrides は host:port から実際に接続する addr:port への対応
+var resolveOverrides map[string]string
+
+// SetResolve は curl の --resolve と同じ host:port:addr の形式で接続先を上書きする
+// addr に port を付けるとそのポートに接続する
+// -target の URL とは別に持つのは、複数のターゲットに同じ上書きを使い、Host や SNI はURLのままにするため
+func SetResolve(entries []string) error {
+	m := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, rest, ok1 := strings.Cut(entry, ":")
+		port, addr, ok2 := strings.Cut(rest, ":")
+		if !ok1 || !ok2 || host == "" || port == "" || addr == "" {
+			return fmt.Errorf("resolve の形式が正しくありません: %s", entry)
+		}
+
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(strings.Trim(addr, "[]"), port)
+		}
+		m[net.JoinHostPort(host, port)] = addr
+	}
+	resolveOverrides = m
+	return nil
+}
+
+var dialer = &net.Dialer{
+	Timeout:   30 * time.Second,
+	KeepAlive: 30 * time.Second,
+}
+
+// dialContext は Host ヘッダーや TLS の SNI はそのままで接続先だけを変える
+func (t *Target) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if t != nil && t.unixSocket != "" {
+		return dialer.DialContext(ctx, "unix", t.unixSocket)
+	}
+	if to, ok := resolveOverrides[addr]; ok {
+		addr = to
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
@@ -0,0 +1,89 @@
+package checker
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func hostHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "1", Path: "/"})
+		w.Write([]byte(r.Host))
+	})
+}
+
+func getHostAndCookie(t *testing.T) (string, int) {
+	t.Helper()
+	s := NewSession()
+	req, _ := s.NewRequest("GET", "/", nil)
+	res, err := s.SendRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	buf := make([]byte, 64)
+	n, _ := res.Body.Read(buf)
+	return string(buf[:n]), len(s.Client.Jar.Cookies(req.URL))
+}
+
+func TestUnixSocketTarget(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "app.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skip(err)
+	}
+	srv := &http.Server{Handler: hostHandler()}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	u, err := SetTargetHost("unix://" + sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetTargetHost("http://localhost")
+
+	if u.Host != UnixSocketHost {
+		t.Errorf("expected %s to eq %s", u.Host, UnixSocketHost)
+	}
+	host, cookies := getHostAndCookie(t)
+	if host != UnixSocketHost {
+		t.Errorf("expected %s to eq %s", host, UnixSocketHost)
+	}
+	if cookies != 1 {
+		t.Errorf("expected %d to eq %d", cookies, 1)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	ts := httptest.NewServer(hostHandler())
+	defer ts.Close()
+	defer SetResolve(nil)
+	defer SetTargetHost("http://localhost")
+
+	if err := SetResolve([]string{"isu.example.com:80:" + ts.Listener.Addr().String()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SetTargetHost("http://isu.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	host, cookies := getHostAndCookie(t)
+	if host != "isu.example.com" {
+		t.Errorf("expected %s to eq %s", host, "isu.example.com")
+	}
+	if cookies != 1 {
+		t.Errorf("expected %d to eq %d", cookies, 1)
+	}
+
+	if err := SetResolve([]string{"isu.example.com:80"}); err == nil {
+		t.Error("expected an entry without addr to fail")
+	}
+	SetResolve([]string{"isu.example.com:443:[::1]"})
+	if got := resolveOverrides["isu.example.com:443"]; got != "[::1]:443" {
+		t.Errorf("expected %s to eq %s", got, "[::1]:443")
+	}
+}
@@ -0,0 +1,90 @@
+package checker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/catatsuy/private-isu/benchmarker/score"
+)
+
+var (
+	dumpDir string
+	dumpSeq atomic.Int64
+)
+
+// SetDumpDir を設定すると失敗したリクエストとレスポンスを dir 以下に保存する
+// レスポンスボディを保存するために全レスポンスをメモリに読み込むようになる
+func SetDumpDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	dumpDir = dir
+	return nil
+}
+
+// capturedBody は読み終わった後もボディの中身を参照できるようにする
+type capturedBody struct {
+	*bytes.Reader
+	data []byte
+}
+
+func (b *capturedBody) Close() error {
+	return nil
+}
+
+func captureBody(res *http.Response) error {
+	data, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+	res.Body = &capturedBody{Reader: bytes.NewReader(data), data: data}
+	return nil
+}
+
+var dumpFileNameReplacer = strings.NewReplacer("/", "_", "\\", "_", " ", "_", ":", "_", "\n", "_")
+
+func writeHeader(w io.Writer, h http.Header) {
+	h.Write(w)
+	fmt.Fprintln(w)
+}
+
+func dumpExchange(f *score.Failure, scenario string, req *http.Request, res *http.Response) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "# %s\n\n", f.Error())
+
+	fmt.Fprintf(&buf, "%s %s\n", req.Method, req.URL)
+	writeHeader(&buf, req.Header)
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			io.Copy(&buf, body)
+			body.Close()
+		}
+	}
+	fmt.Fprintln(&buf)
+
+	if res != nil {
+		fmt.Fprintf(&buf, "\n%s %s\n", res.Proto, res.Status)
+		if res.Request.URL.String() != req.URL.String() {
+			fmt.Fprintf(&buf, "# %s %s\n", res.Request.Method, res.Request.URL)
+		}
+		writeHeader(&buf, res.Header)
+		if b, ok := res.Body.(*capturedBody); ok {
+			buf.Write(b.data)
+		}
+	}
+
+	name := fmt.Sprintf("%06d_%s_%s.txt", dumpSeq.Add(1), scenario, f.Description)
+	name = dumpFileNameReplacer.Replace(name)
+
+	if err := os.WriteFile(filepath.Join(dumpDir, name), buf.Bytes(), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
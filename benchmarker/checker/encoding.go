@@ -0,0 +1,170 @@
+package checker
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/catatsuy/private-isu/benchmarker/score"
+	"github.com/klauspost/compress/zstd"
+)
+
+// 既定では http.Transport と同じく gzip だけを受け付ける
+var acceptEncoding = "gzip"
+
+// SetAcceptEncoding はリクエストに付ける Accept-Encoding を設定する
+// 空ならヘッダーを付けない
+func SetAcceptEncoding(v string) {
+	acceptEncoding = v
+}
+
+// encodingError はサーバーが正しく圧縮していなかったときのエラー
+type encodingError struct {
+	encoding string
+	err      error
+}
+
+func (e *encodingError) Error() string {
+	return fmt.Sprintf("%s: %s", e.encoding, e.err)
+}
+
+func (e *encodingError) Unwrap() error {
+	return e.err
+}
+
+var errNotAccepted = errors.New("Accept-Encoding に含まれていません")
+var errTooLarge = errors.New("展開後の大きさが上限を超えました")
+
+// 圧縮爆弾で落ちないように、展開後の大きさは転送量の maxDecodeRatio 倍までにする
+// 小さいレスポンスでも minDecodeLimit までは許す
+const (
+	maxDecodeRatio = 100
+	minDecodeLimit = 10 << 20
+)
+
+// acceptsEncoding は q=0 で拒否していなければ true
+func acceptsEncoding(enc string) bool {
+	star := false
+	for _, v := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(v), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		accepted := strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+		if name == enc {
+			return accepted
+		}
+		if name == "*" {
+			star = accepted
+		}
+	}
+	return star
+}
+
+func decodeContent(enc string, data []byte, limit int64) ([]byte, error) {
+	var r io.Reader
+	var err error
+	switch enc {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case "deflate":
+		// 仕様では zlib だが、zlib ヘッダーの無い deflate を返すサーバーもある
+		r, err = zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			r, err = flate.NewReader(bytes.NewReader(data)), nil
+		}
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(data))
+	case "zstd":
+		d, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer d.Close()
+		r = d
+	default:
+		return nil, errors.New("対応していない形式です")
+	}
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decoded)) > limit {
+		return nil, errTooLarge
+	}
+	return decoded, nil
+}
+
+// contentEncodings はかけた順に返す。identity は除く
+func contentEncodings(h http.Header) []string {
+	var encodings []string
+	for _, v := range h.Values("Content-Encoding") {
+		for _, enc := range strings.Split(v, ",") {
+			if enc = strings.ToLower(strings.TrimSpace(enc)); enc != "" && enc != "identity" {
+				encodings = append(encodings, enc)
+			}
+		}
+	}
+	return encodings
+}
+
+// decodeEncodings は複数あるときは最後にかけたものから戻す
+func decodeEncodings(encodings []string, wire []byte) ([]byte, error) {
+	limit := max(int64(len(wire))*maxDecodeRatio, minDecodeLimit)
+	data := wire
+	for i := len(encodings) - 1; i >= 0; i-- {
+		enc := encodings[i]
+		if !acceptsEncoding(enc) {
+			return nil, &encodingError{encoding: enc, err: errNotAccepted}
+		}
+		var err error
+		data, err = decodeContent(enc, data, limit)
+		if err != nil {
+			return nil, &encodingError{encoding: enc, err: err}
+		}
+	}
+	return data, nil
+}
+
+// decodeBody はボディを読み切って Content-Encoding を展開し、転送量をルートごとに記録する
+// http.Transport の自動展開は使わないので、圧縮されたままのバイト数がわかる
+func decodeBody(res *http.Response, route string) error {
+	wire, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	data := wire
+	encodings := contentEncodings(res.Header)
+
+	// 304やHEADのときはボディが無い
+	if len(wire) > 0 {
+		data, err = decodeEncodings(encodings, wire)
+		if err != nil {
+			return err
+		}
+	}
+
+	score.GetTransferInstance().Record(route, int64(len(wire)), int64(len(data)), len(encodings) > 0)
+
+	if len(encodings) > 0 {
+		res.Header.Del("Content-Encoding")
+		res.Header.Del("Content-Length")
+		res.ContentLength = int64(len(data))
+		res.Uncompressed = true
+	}
+	res.Body = io.NopCloser(bytes.NewReader(data))
+	return nil
+}
@@ -0,0 +1,116 @@
+package checker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func encodedResponse(enc string, body []byte) *http.Response {
+	res := &http.Response{Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(body))}
+	if enc != "" {
+		res.Header.Set("Content-Encoding", enc)
+	}
+	return res
+}
+
+func TestDecodeBody(t *testing.T) {
+	defer SetAcceptEncoding("gzip")
+	SetAcceptEncoding("br, zstd, gzip;q=0.5")
+
+	plain := []byte(strings.Repeat("<p>private-isu</p>", 100))
+
+	var gz, br, zs bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(plain)
+	w.Close()
+	bw := brotli.NewWriter(&br)
+	bw.Write(plain)
+	bw.Close()
+	zw, _ := zstd.NewWriter(&zs)
+	zw.Write(plain)
+	zw.Close()
+
+	for enc, body := range map[string][]byte{"": plain, "gzip": gz.Bytes(), "br": br.Bytes(), "zstd": zs.Bytes()} {
+		res := encodedResponse(enc, body)
+		if err := decodeBody(res, "GET /"); err != nil {
+			t.Fatalf("%s: %s", enc, err)
+		}
+		got, _ := io.ReadAll(res.Body)
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%s: expected %d bytes to eq %d bytes", enc, len(got), len(plain))
+		}
+		if res.Header.Get("Content-Encoding") != "" {
+			t.Errorf("%s: expected Content-Encoding to be removed", enc)
+		}
+	}
+
+	var encErr *encodingError
+
+	// 壊れたボディ
+	err := decodeBody(encodedResponse("gzip", plain), "GET /")
+	if !errors.As(err, &encErr) {
+		t.Errorf("expected encodingError, got %v", err)
+	}
+
+	// 送っていない形式
+	err = decodeBody(encodedResponse("deflate", gz.Bytes()), "GET /")
+	if !errors.As(err, &encErr) {
+		t.Errorf("expected encodingError, got %v", err)
+	}
+
+	// 展開すると大きくなりすぎるもの
+	var bomb bytes.Buffer
+	w = gzip.NewWriter(&bomb)
+	w.Write(make([]byte, 2*minDecodeLimit))
+	w.Close()
+	err = decodeBody(encodedResponse("gzip", bomb.Bytes()), "GET /")
+	if !errors.Is(err, errTooLarge) {
+		t.Errorf("expected %v to eq %v", err, errTooLarge)
+	}
+}
+
+func TestHARBodyDecodesText(t *testing.T) {
+	plain := strings.Repeat("<p>private-isu</p>", 100)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(plain))
+	w.Close()
+
+	e := &harEntry{}
+	b := &harBody{
+		ReadCloser: io.NopCloser(bytes.NewReader(gz.Bytes())),
+		entry:      e,
+		recorder:   &harRecorder{},
+		received:   time.Now(),
+		keepText:   true,
+		encodings:  []string{"gzip"},
+	}
+	b.Close()
+
+	if e.Response.Content.Text != plain {
+		t.Errorf("expected %q to eq %q", e.Response.Content.Text, plain)
+	}
+	if e.Response.BodySize != int64(gz.Len()) {
+		t.Errorf("expected %d to eq %d", e.Response.BodySize, gz.Len())
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	defer SetAcceptEncoding("gzip")
+
+	SetAcceptEncoding("gzip, br;q=0, *")
+	for enc, expected := range map[string]bool{"gzip": true, "br": false, "zstd": true} {
+		if got := acceptsEncoding(enc); got != expected {
+			t.Errorf("%s: expected %v to eq %v", enc, got, expected)
+		}
+	}
+}
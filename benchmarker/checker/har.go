@@ -0,0 +1,345 @@
+package checker
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HTTP Archive 1.2 形式で全リクエストを記録する
+// http://www.softwareishard.com/blog/har-12-spec/
+
+type harLog struct {
+	Log harContainer `json:"log"`
+}
+
+type harContainer struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Scenario        string      `json:"_scenario,omitempty"`
+	Session         int64       `json:"_session,omitempty"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+// 使い回した接続のときなど、該当しない項目は -1
+// Connect は SSL の時間も含む
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+func (t harTimings) total() float64 {
+	total := t.Send + t.Wait + t.Receive
+	for _, v := range []float64{t.Blocked, t.DNS, t.Connect} {
+		if v > 0 {
+			total += v
+		}
+	}
+	return total
+}
+
+type harRecorder struct {
+	sync.Mutex
+	entries  []*harEntry
+	sessions atomic.Int64
+}
+
+var harInstance *harRecorder
+
+// EnableHAR を呼ぶとそれ以降に作ったセッションのリクエストを記録する
+func EnableHAR() {
+	harInstance = &harRecorder{}
+}
+
+func (h *harRecorder) add(e *harEntry) {
+	h.Lock()
+	h.entries = append(h.entries, e)
+	h.Unlock()
+}
+
+// WriteHAR は記録したリクエストを path に書き出す
+// perScenario なら path の拡張子の前にシナリオ名を付けてシナリオごとに分ける
+func WriteHAR(path string, perScenario bool) error {
+	if harInstance == nil {
+		return nil
+	}
+
+	harInstance.Lock()
+	entries := slices.Clone(harInstance.entries)
+	harInstance.Unlock()
+
+	slices.SortStableFunc(entries, func(a, b *harEntry) int {
+		return a.StartedDateTime.Compare(b.StartedDateTime)
+	})
+
+	if !perScenario {
+		return writeHARFile(path, entries)
+	}
+
+	groups := map[string][]*harEntry{}
+	for _, e := range entries {
+		groups[e.Scenario] = append(groups[e.Scenario], e)
+	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for scenario, es := range groups {
+		name := scenario
+		if name == "" {
+			name = "unknown"
+		}
+		if err := writeHARFile(base+"-"+name+ext, es); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeHARFile(path string, entries []*harEntry) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(harLog{
+		Log: harContainer{
+			Version: "1.2",
+			Creator: harCreator{Name: UserAgent, Version: "1.0"},
+			Entries: entries,
+		},
+	})
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	nvs := []harNameValue{}
+	for name, values := range h {
+		for _, v := range values {
+			nvs = append(nvs, harNameValue{Name: name, Value: v})
+		}
+	}
+	slices.SortFunc(nvs, func(a, b harNameValue) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return nvs
+}
+
+func harCookies(cookies []*http.Cookie) []harNameValue {
+	nvs := []harNameValue{}
+	for _, c := range cookies {
+		nvs = append(nvs, harNameValue{Name: c.Name, Value: c.Value})
+	}
+	return nvs
+}
+
+func msSince(t time.Time) float64 {
+	return float64(time.Since(t).Microseconds()) / 1000
+}
+
+// テキストのレスポンスだけ中身を保存する。画像まで入れると大きくなりすぎる
+func isTextContent(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "text/") ||
+		strings.Contains(mimeType, "javascript") ||
+		strings.Contains(mimeType, "json")
+}
+
+func newHAREntry(scenario string, session int64, req *http.Request, start time.Time) *harEntry {
+	e := &harEntry{
+		StartedDateTime: start,
+		Scenario:        scenario,
+		Session:         session,
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: req.Proto,
+			Cookies:     harCookies(req.Cookies()),
+			Headers:     harHeaders(req.Header),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    req.ContentLength,
+		},
+	}
+
+	for name, values := range req.URL.Query() {
+		for _, v := range values {
+			e.Request.QueryString = append(e.Request.QueryString, harNameValue{Name: name, Value: v})
+		}
+	}
+
+	mimeType := req.Header.Get("Content-Type")
+	if req.GetBody != nil && mimeType != "" {
+		e.Request.PostData = &harPostData{MimeType: mimeType}
+		if strings.HasPrefix(mimeType, "application/x-www-form-urlencoded") {
+			if body, err := req.GetBody(); err == nil {
+				b, _ := io.ReadAll(body)
+				body.Close()
+				e.Request.PostData.Text = string(b)
+			}
+		}
+	}
+
+	return e
+}
+
+// harTransport はリダイレクトも含めて1往復ごとに記録する
+// id はどのリクエストが同じセッション(cookie)から送られたかを区別するためのもの
+type harTransport struct {
+	base     http.RoundTripper
+	session  *Session
+	id       int64
+	recorder *harRecorder
+}
+
+func (t *harTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	e := newHAREntry(t.session.Scenario, t.id, req, start)
+
+	req, c := traceRequest(req)
+	res, err := t.base.RoundTrip(req)
+	e.Timings = c.harTimings()
+
+	if err != nil {
+		e.Error = err.Error()
+		e.Time = msSince(start)
+		e.Timings.Receive = 0
+		t.recorder.add(e)
+		return nil, err
+	}
+
+	mimeType := res.Header.Get("Content-Type")
+	e.Response = harResponse{
+		Status:      res.StatusCode,
+		StatusText:  http.StatusText(res.StatusCode),
+		HTTPVersion: res.Proto,
+		Cookies:     harCookies(res.Cookies()),
+		Headers:     harHeaders(res.Header),
+		Content:     harContent{MimeType: mimeType},
+		RedirectURL: res.Header.Get("Location"),
+		HeadersSize: -1,
+	}
+
+	res.Body = &harBody{
+		ReadCloser: res.Body,
+		entry:      e,
+		recorder:   t.recorder,
+		received:   time.Now(),
+		keepText:   isTextContent(mimeType),
+		encodings:  contentEncodings(res.Header),
+	}
+
+	return res, nil
+}
+
+// harBody はボディを読み終わって閉じたところでエントリを確定させる
+type harBody struct {
+	io.ReadCloser
+	entry    *harEntry
+	recorder *harRecorder
+	received time.Time
+	keepText bool
+	// encodings があれば保存する前に展開する
+	encodings []string
+	size      int64
+	text      strings.Builder
+	once      sync.Once
+}
+
+func (b *harBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += int64(n)
+	if b.keepText {
+		b.text.Write(p[:n])
+	}
+	return n, err
+}
+
+// 読まれなかった残りも記録するために読み切ってから閉じる
+func (b *harBody) Close() error {
+	io.Copy(io.Discard, b)
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		e := b.entry
+		e.Timings.Receive = msSince(b.received)
+		e.Time = e.Timings.total()
+		e.Response.BodySize = b.size
+		e.Response.Content.Size = b.size
+		e.Response.Content.Text = b.text.String()
+		if len(b.encodings) > 0 && b.keepText {
+			// Content.Size は展開後の大きさ。展開できなければ中身は残さない
+			e.Response.Content.Text = ""
+			if data, err := decodeEncodings(b.encodings, []byte(b.text.String())); err == nil {
+				e.Response.Content.Size = int64(len(data))
+				e.Response.Content.Text = string(data)
+			}
+		}
+		b.recorder.add(e)
+	})
+	return err
+}
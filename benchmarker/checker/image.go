@@ -0,0 +1,198 @@
+package checker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math/bits"
+	"mime"
+	"strconv"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	ImageVerifyMD5    = "md5"
+	ImageVerifyDecode = "decode"
+)
+
+var (
+	imageVerify    = ImageVerifyMD5
+	imageTolerance = 0
+	imageAccept    = ""
+)
+
+// SetImageVerify は投稿画像の確認方法を設定する
+// decode ならデコードして形式、大きさ、dHashのハミング距離が tolerance 以下なら一致とみなす
+func SetImageVerify(mode string, tolerance int) {
+	imageVerify = mode
+	imageTolerance = tolerance
+}
+
+// SetImageAccept は画像のリクエストに付ける Accept ヘッダーを設定する
+// 空ならヘッダーを付けない。AVIF はデコードして確かめられないので受け付けない
+func SetImageAccept(accept string) error {
+	imageAccept = accept
+	if acceptsImageFormat("avif") {
+		imageAccept = ""
+		return errors.New("image-accept cannot list image/avif because AVIF images cannot be decoded")
+	}
+	return nil
+}
+
+// NewImageAction は投稿画像を読み込む。ブラウザと同じように Accept ヘッダーを付ける
+func NewImageAction(path string, asset *Asset) *AssetAction {
+	a := NewAssetAction(path, asset)
+	if imageAccept != "" {
+		a.Headers = map[string]string{"Accept": imageAccept}
+	}
+	return a
+}
+
+// acceptsImageFormat は Accept ヘッダーで format を明示していれば true
+// */* や image/* はブラウザも付けるので、それだけでは別の形式を受け付けたことにしない
+func acceptsImageFormat(format string) bool {
+	for _, v := range strings.Split(imageAccept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err != nil || mediaType != "image/"+format {
+			continue
+		}
+		if q, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(q, 64); err == nil && f == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// ImageFingerprint は画像を再圧縮したりメタデータを削っても変わらない特徴
+type ImageFingerprint struct {
+	Format string
+	Width  int
+	Height int
+	Hash   uint64
+}
+
+func NewImageFingerprint(r io.Reader) (*ImageFingerprint, error) {
+	img, format, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	b := img.Bounds()
+	return &ImageFingerprint{
+		Format: format,
+		Width:  b.Dx(),
+		Height: b.Dy(),
+		Hash:   dHash(img),
+	}, nil
+}
+
+// Compare は一致しないときにその理由を返す
+func (f *ImageFingerprint) Compare(got *ImageFingerprint, tolerance int) error {
+	if f.Format != got.Format {
+		return fmt.Errorf("画像の形式が正しくありません: expected %s, got %s", f.Format, got.Format)
+	}
+	if f.Width != got.Width || f.Height != got.Height {
+		return fmt.Errorf("画像の大きさが正しくありません: expected %dx%d, got %dx%d", f.Width, f.Height, got.Width, got.Height)
+	}
+	if d := bits.OnesCount64(f.Hash ^ got.Hash); d > tolerance {
+		return fmt.Errorf("画像の内容が正しくありません: distance %d", d)
+	}
+	return nil
+}
+
+// dHash は9x8に縮小したグレースケール画像の隣り合うピクセルの大小を64bitにする
+func dHash(img image.Image) uint64 {
+	const w, h = 9, 8
+	var gray [h][w]float64
+
+	b := img.Bounds()
+	for y := range h {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(b.Min.Y+(y+1)*b.Dy()/h, y0+1)
+		for x := range w {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(b.Min.X+(x+1)*b.Dx()/w, x0+1)
+
+			// 大きい画像でも遅くならないように1マスあたり最大16x16点だけ見る
+			sy := max((y1-y0)/16, 1)
+			sx := max((x1-x0)/16, 1)
+			var sum float64
+			var n int
+			for py := y0; py < y1; py += sy {
+				for px := x0; px < x1; px += sx {
+					r, g, b, _ := img.At(px, py).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+					n++
+				}
+			}
+			gray[y][x] = sum / float64(n)
+		}
+	}
+
+	var hash uint64
+	for y := range h {
+		for x := range w - 1 {
+			hash <<= 1
+			if gray[y][x] < gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// AVIF をデコードできるライブラリが無いので、返してきたら同じ画像か確かめられず失敗にする
+var errAVIF = errors.New("AVIFの画像には対応していません")
+
+// isAVIF は ISOBMFF の ftyp ボックスの brand を見る
+func isAVIF(data []byte) bool {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	size := int(binary.BigEndian.Uint32(data[0:4]))
+	if size < 16 || size > len(data) {
+		return false
+	}
+	// major brand と compatible brands
+	for i := 8; i+4 <= size; i += 4 {
+		if i == 12 {
+			continue // minor version
+		}
+		switch string(data[i : i+4]) {
+		case "avif", "avis":
+			return true
+		}
+	}
+	return false
+}
+
+// verifyImage はMD5が一致しなかった投稿画像の中身を確認する
+// Accept ヘッダーで受け付けた形式なら、形式が違っても同じ画像であれば成功にする
+func verifyImage(asset *Asset, data []byte) error {
+	if isAVIF(data) {
+		return errAVIF
+	}
+	got, err := NewImageFingerprint(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("画像をデコードできませんでした")
+	}
+
+	want := *asset.Image
+	if got.Format != want.Format && acceptsImageFormat(got.Format) {
+		want.Format = got.Format
+	} else if got.Format == want.Format && imageVerify != ImageVerifyDecode {
+		return fmt.Errorf("静的ファイルが正しくありません")
+	}
+	return want.Compare(got, imageTolerance)
+}
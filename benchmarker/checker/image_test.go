@@ -0,0 +1,134 @@
+package checker
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func gradient(w, h int, flip bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			v := uint8(x * 255 / w)
+			if flip {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, uint8(y * 255 / h), 128, 255})
+		}
+	}
+	return img
+}
+
+func fingerprint(t *testing.T, buf *bytes.Buffer) *ImageFingerprint {
+	t.Helper()
+	f, err := NewImageFingerprint(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestImageFingerprint(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, gradient(120, 80, false))
+	orig := fingerprint(t, &buf)
+
+	// 圧縮率を変えただけなら一致する
+	buf.Reset()
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	enc.Encode(&buf, gradient(120, 80, false))
+	if err := orig.Compare(fingerprint(t, &buf), 0); err != nil {
+		t.Errorf("expected recompressed image to match: %s", err)
+	}
+
+	buf.Reset()
+	jpeg.Encode(&buf, gradient(120, 80, false), nil)
+	if err := orig.Compare(fingerprint(t, &buf), 4); err == nil {
+		t.Error("expected a different format not to match")
+	}
+
+	buf.Reset()
+	png.Encode(&buf, gradient(60, 40, false))
+	if err := orig.Compare(fingerprint(t, &buf), 4); err == nil {
+		t.Error("expected a different size not to match")
+	}
+
+	buf.Reset()
+	png.Encode(&buf, gradient(120, 80, true))
+	if err := orig.Compare(fingerprint(t, &buf), 4); err == nil {
+		t.Error("expected a different image not to match")
+	}
+}
+
+func TestImageFingerprintJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, gradient(120, 80, false), &jpeg.Options{Quality: 95})
+	orig := fingerprint(t, &buf)
+
+	buf.Reset()
+	jpeg.Encode(&buf, gradient(120, 80, false), &jpeg.Options{Quality: 60})
+	if err := orig.Compare(fingerprint(t, &buf), 4); err != nil {
+		t.Errorf("expected recompressed jpeg to match: %s", err)
+	}
+}
+
+func TestVerifyImageNegotiated(t *testing.T) {
+	defer SetImageAccept("")
+
+	var buf bytes.Buffer
+	png.Encode(&buf, gradient(120, 80, false))
+	asset := &Asset{Image: fingerprint(t, &buf)}
+
+	buf.Reset()
+	jpeg.Encode(&buf, gradient(120, 80, false), &jpeg.Options{Quality: 90})
+
+	SetImageAccept("image/webp,*/*")
+	if err := verifyImage(asset, buf.Bytes()); err == nil {
+		t.Error("expected a format not in Accept to fail")
+	}
+
+	SetImageAccept("image/jpeg;q=0.9,*/*;q=0.8")
+	if err := verifyImage(asset, buf.Bytes()); err != nil {
+		t.Errorf("expected a negotiated format to pass: %s", err)
+	}
+}
+
+func box(typ string, body ...[]byte) []byte {
+	b := bytes.Join(body, nil)
+	size := uint32(len(b) + 8)
+	return append([]byte{byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size), typ[0], typ[1], typ[2], typ[3]}, b...)
+}
+
+func u32(v uint32) []byte {
+	return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+func TestAVIF(t *testing.T) {
+	defer SetImageAccept("")
+
+	data := bytes.Join([][]byte{
+		box("ftyp", []byte("avif"), u32(0), []byte("mif1")),
+		box("mdat", []byte{0, 1, 2}),
+	}, nil)
+	if !isAVIF(data) {
+		t.Fatal("expected data to be AVIF")
+	}
+
+	// デコードできないので同じ画像か確かめられず失敗にする
+	asset := &Asset{Image: &ImageFingerprint{Format: "png", Width: 120, Height: 80}}
+	if err := verifyImage(asset, data); !errors.Is(err, errAVIF) {
+		t.Errorf("expected %v to eq %v", err, errAVIF)
+	}
+
+	if err := SetImageAccept("image/avif,image/webp,*/*"); err == nil {
+		t.Error("expected image/avif to be rejected")
+	}
+	if err := SetImageAccept("image/avif;q=0,image/webp,*/*"); err != nil {
+		t.Errorf("expected image/avif;q=0 to be allowed: %s", err)
+	}
+}
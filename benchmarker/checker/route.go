@@ -0,0 +1,31 @@
+package checker
+
+import (
+	"regexp"
+	"strings"
+)
+
+var routePatterns = []struct {
+	re      *regexp.Regexp
+	pattern string
+}{
+	{regexp.MustCompile(`^/posts/\d+$`), "/posts/:id"},
+	{regexp.MustCompile(`^/image/\d+\.\w+$`), "/image/:id"},
+	{regexp.MustCompile(`^/@[0-9a-zA-Z_]+$`), "/@:account_name"},
+}
+
+// RoutePattern はメソッドとパスからwebappのルーティング単位の名前を返す
+// 静的ファイルなどはパスをそのまま使う
+func RoutePattern(method, path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	for _, r := range routePatterns {
+		if r.re.MatchString(path) {
+			return method + " " + r.pattern
+		}
+	}
+
+	return method + " " + path
+}
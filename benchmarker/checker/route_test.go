@@ -0,0 +1,23 @@
+package checker
+
+import "testing"
+
+func TestRoutePattern(t *testing.T) {
+	tests := []struct {
+		method, path, expected string
+	}{
+		{"GET", "/", "GET /"},
+		{"POST", "/", "POST /"},
+		{"GET", "/posts", "GET /posts"},
+		{"GET", "/posts/123", "GET /posts/:id"},
+		{"GET", "/image/42.jpg", "GET /image/:id"},
+		{"GET", "/@mary", "GET /@:account_name"},
+		{"GET", "js/timeago.min.js", "GET /js/timeago.min.js"},
+	}
+
+	for _, tt := range tests {
+		if got := RoutePattern(tt.method, tt.path); got != tt.expected {
+			t.Errorf("RoutePattern(%q, %q) = %q, expected %q", tt.method, tt.path, got, tt.expected)
+		}
+	}
+}
@@ -15,7 +15,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/catatsuy/private-isu/benchmarker/cache"
 	"github.com/catatsuy/private-isu/benchmarker/score"
+	"github.com/catatsuy/private-isu/benchmarker/util"
 )
 
 const (
@@ -23,60 +25,63 @@ const (
 )
 
 var (
-	targetHost *url.URL
+	maxConnsPerHost int
 )
 
 type Session struct {
 	Client    *http.Client
 	Transport *http.Transport
 
+	// Scenario はスコアをシナリオごとに集計するための名前
+	Scenario string
+	// Rand はシナリオ中のランダムな値を選ぶのに使う。nil ならグローバルな乱数
+	Rand *util.Rand
+	// Cache はブラウザと同じくセッションごとに持つ
+	Cache *cache.CacheStore
+	// Target はこのセッションがリクエストを送る先。cookie がずれないように途中では変えない
+	Target *Target
+
 	logger *log.Logger
 }
 
 func NewSession() *Session {
+	return NewSessionWithRand(nil)
+}
+
+// NewSessionWithRand は r を Rand に設定し、送り先も r で選ぶ
+// -seed を指定したときに goroutine の実行順に関係なく同じ送り先になる
+func NewSessionWithRand(r *util.Rand) *Session {
 	w := &Session{
+		Rand:   r,
+		Cache:  cache.NewCacheStore(),
+		Target: pickTarget(r),
 		logger: log.New(os.Stdout, "", 0),
 	}
 
 	jar, _ := cookiejar.New(&cookiejar.Options{})
-	w.Transport = &http.Transport{}
+	w.Transport = NewTransport(w.Target)
 	w.Client = &http.Client{
-		Transport: w.Transport,
+		Transport: &traceTransport{base: w.Transport},
 		Jar:       jar,
 		Timeout:   time.Duration(10) * time.Second,
 	}
 
-	return w
-}
-
-func SetTargetHost(host string) (*url.URL, error) {
-	parsedURL, err := urlParse(host)
-	if err != nil {
-		return nil, err
+	if harInstance != nil {
+		w.Client.Transport = &harTransport{
+			base:     w.Client.Transport,
+			session:  w,
+			id:       harInstance.sessions.Add(1),
+			recorder: harInstance,
+		}
 	}
 
-	targetHost = parsedURL
-	return targetHost, nil
+	return w
 }
 
-func urlParse(ref string) (*url.URL, error) {
-	u, err := url.Parse(ref)
-	if err != nil {
-		return nil, err
-	}
-
-	if u.Host == "" {
-		return nil, fmt.Errorf("host is empty")
-	}
-
-	if u.Scheme == "" {
-		u.Scheme = "http"
-	}
-
-	return &url.URL{
-		Scheme: u.Scheme,
-		Host:   u.Host,
-	}, nil
+// SetMaxConnsPerHost はセッションごとのホストあたりの最大コネクション数を設定する
+// 0 なら制限しない
+func SetMaxConnsPerHost(n int) {
+	maxConnsPerHost = n
 }
 
 func (s *Session) NewRequest(method, uri string, body io.Reader) (*http.Request, error) {
@@ -87,11 +92,11 @@ func (s *Session) NewRequest(method, uri string, body io.Reader) (*http.Request,
 	}
 
 	if parsedURL.Scheme == "" {
-		parsedURL.Scheme = targetHost.Scheme
+		parsedURL.Scheme = s.Target.URL.Scheme
 	}
 
 	if parsedURL.Host == "" {
-		parsedURL.Host = targetHost.Host
+		parsedURL.Host = s.Target.URL.Host
 	}
 
 	req, err := http.NewRequest(method, parsedURL.String(), body)
@@ -100,6 +105,11 @@ func (s *Session) NewRequest(method, uri string, body io.Reader) (*http.Request,
 		return nil, err
 	}
 
+	// キャッシュの Vary と比べられるように送る前から付けておく
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+
 	return req, err
 }
 
@@ -143,14 +153,17 @@ func (s *Session) NewFileUploadRequest(uri string, params map[string]string, par
 	}
 
 	parsedURL := &url.URL{
-		Scheme: targetHost.Scheme,
-		Host:   targetHost.Host,
+		Scheme: s.Target.URL.Scheme,
+		Host:   s.Target.URL.Host,
 		Path:   uri,
 	}
 
 	req, err := http.NewRequest("POST", parsedURL.String(), body)
 	if err == nil {
 		req.Header.Add("Content-Type", writer.FormDataContentType())
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
 	} else {
 		return nil, err
 	}
@@ -161,19 +174,67 @@ func (s *Session) NewFileUploadRequest(uri string, params map[string]string, par
 func (s *Session) SendRequest(req *http.Request) (*http.Response, error) {
 	req.Header.Set("User-Agent", UserAgent)
 
-	return s.Client.Do(req)
+	score.GetTimelineInstance().IncInFlight()
+	defer score.GetTimelineInstance().DecInFlight()
+
+	route := RoutePattern(req.Method, req.URL.Path)
+
+	start := time.Now()
+	res, err := s.Client.Do(req)
+	if err != nil {
+		// タイムアウトや接続エラーもそこまでにかかった時間で記録する
+		s.recordLatency(route, time.Since(start))
+		return res, err
+	}
+
+	// ボディを読み終わるまでをレスポンスタイムとする
+	err = decodeBody(res, route)
+	s.recordLatency(route, time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+
+	if dumpDir != "" {
+		if err := captureBody(res); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func (s *Session) recordLatency(route string, elapsed time.Duration) {
+	score.GetLatencyInstance().Record(route, elapsed)
+	if s.Target != nil {
+		score.GetHostLatencyInstance().Record(s.Target.Name, elapsed)
+	}
 }
 
 func (s *Session) Success(point int64) {
 	score.GetInstance().SetScore(point)
+	score.GetTimelineInstance().AddSuccess(point)
+	if s.Scenario != "" {
+		score.GetScenarioInstance(s.Scenario).SetScore(point)
+	}
+	if s.Target != nil {
+		score.GetHostInstance(s.Target.Name).SetScore(point)
+	}
 }
 
-func (s *Session) Fail(point int64, req *http.Request, err error) error {
+func (s *Session) Fail(point int64, f *score.Failure) error {
 	score.GetInstance().SetFails(point)
-	if req != nil {
-		err = fmt.Errorf("%s (%s %s)", err, req.Method, req.URL.Path)
+	score.GetTimelineInstance().AddFail(point)
+	if s.Scenario != "" {
+		score.GetScenarioInstance(s.Scenario).SetFails(point)
 	}
+	if s.Target != nil {
+		score.GetHostInstance(s.Target.Name).SetFails(point)
+		f.Host = s.Target.Name
+	}
+
+	f.Scenario = s.Scenario
+	f.Time = time.Now()
 
-	score.GetFailErrorsInstance().Append(err)
-	return err
+	score.GetFailErrorsInstance().Append(f)
+	return f
 }
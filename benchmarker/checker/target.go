@@ -0,0 +1,173 @@
+package checker
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+// Target は負荷をかける先の1台
+type Target struct {
+	// Name は -target に指定したもの。ホストごとの集計に使う
+	Name   string
+	URL    *url.URL
+	Weight int
+
+	unixSocket string
+	// current は重み付きラウンドロビンで使う
+	current int
+}
+
+var (
+	targets  []*Target
+	targetMu sync.Mutex
+)
+
+// SetTargetHosts はセッションを振り分ける先を設定する
+// url=weight の形式で重みを付けられる。重みが同じならラウンドロビンになる
+func SetTargetHosts(refs []string) ([]*Target, error) {
+	ts := make([]*Target, 0, len(refs))
+	for _, ref := range refs {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			continue
+		}
+		t, err := parseTarget(ref)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	if len(ts) == 0 {
+		return nil, fmt.Errorf("host is empty")
+	}
+
+	targetMu.Lock()
+	targets = ts
+	targetMu.Unlock()
+	return ts, nil
+}
+
+// SetTargetHost は1台だけに負荷をかけるときに使う
+func SetTargetHost(host string) (*url.URL, error) {
+	ts, err := SetTargetHosts([]string{host})
+	if err != nil {
+		return nil, err
+	}
+	return ts[0].URL, nil
+}
+
+func parseTarget(ref string) (*Target, error) {
+	t := &Target{Weight: 1}
+
+	if i := strings.LastIndex(ref, "="); i >= 0 {
+		w, err := strconv.Atoi(ref[i+1:])
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("target の重みが正しくありません: %s", ref)
+		}
+		t.Weight = w
+		ref = ref[:i]
+	}
+	t.Name = ref
+
+	// unix:///path.sock のターゲットは Host を UnixSocketHost にした http の URL として扱う
+	if path, ok := strings.CutPrefix(ref, "unix://"); ok {
+		if path == "" {
+			return nil, fmt.Errorf("socket path is empty")
+		}
+		t.unixSocket = path
+		t.URL = &url.URL{Scheme: "http", Host: UnixSocketHost}
+		return t, nil
+	}
+
+	u, err := urlParse(ref)
+	if err != nil {
+		return nil, err
+	}
+	t.URL = u
+	return t, nil
+}
+
+func urlParse(ref string) (*url.URL, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("host is empty")
+	}
+
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+
+	return &url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+	}, nil
+}
+
+// nextTarget は nginx と同じ smooth weighted round-robin で次の1台を選ぶ
+func nextTarget() *Target {
+	targetMu.Lock()
+	defer targetMu.Unlock()
+
+	if len(targets) == 0 {
+		return nil
+	}
+
+	var best *Target
+	total := 0
+	for _, t := range targets {
+		t.current += t.Weight
+		total += t.Weight
+		if best == nil || t.current > best.current {
+			best = t
+		}
+	}
+	best.current -= total
+	return best
+}
+
+// pickTarget は r があれば重みに応じて r で1台選ぶ。nil なら nextTarget と同じ
+// 1台だけのときは r を使わないので、それ以降の乱数列は変わらない
+func pickTarget(r *util.Rand) *Target {
+	if r == nil {
+		return nextTarget()
+	}
+
+	targetMu.Lock()
+	defer targetMu.Unlock()
+
+	switch len(targets) {
+	case 0:
+		return nil
+	case 1:
+		return targets[0]
+	}
+
+	total := 0
+	for _, t := range targets {
+		total += t.Weight
+	}
+	n := r.Number(total)
+	for _, t := range targets {
+		if n < t.Weight {
+			return t
+		}
+		n -= t.Weight
+	}
+	return targets[len(targets)-1]
+}
+
+// MultipleTargets は複数台に振り分けているときに true
+func MultipleTargets() bool {
+	targetMu.Lock()
+	defer targetMu.Unlock()
+	return len(targets) > 1
+}
@@ -0,0 +1,108 @@
+package checker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/catatsuy/private-isu/benchmarker/score"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+func TestNextTarget(t *testing.T) {
+	defer SetTargetHost("http://localhost")
+
+	if _, err := SetTargetHosts([]string{"http://a=2", "http://b", " "}); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]int{}
+	for range 30 {
+		counts[nextTarget().Name]++
+	}
+	if counts["http://a"] != 20 || counts["http://b"] != 10 {
+		t.Errorf("expected %v to eq map[http://a:20 http://b:10]", counts)
+	}
+
+	for _, ref := range []string{"http://a=0", "http://a=x", "unix://", ""} {
+		if _, err := SetTargetHosts([]string{ref}); err == nil {
+			t.Errorf("expected %q to fail", ref)
+		}
+	}
+}
+
+func TestPickTarget(t *testing.T) {
+	defer SetTargetHost("http://localhost")
+
+	if _, err := SetTargetHosts([]string{"http://a=3", "http://b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	pick := func() []string {
+		r := util.NewRand(1, "ban", 1)
+		names := make([]string, 0, 20)
+		for range 20 {
+			names = append(names, pickTarget(r).Name)
+		}
+		return names
+	}
+
+	// 間に他のセッションが作られても同じ seed なら同じ順になる
+	first := pick()
+	nextTarget()
+	second := pick()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("expected %v to eq %v", second, first)
+		}
+	}
+
+	counts := map[string]int{}
+	for _, name := range first {
+		counts[name]++
+	}
+	if counts["http://a"] == 0 || counts["http://b"] == 0 {
+		t.Errorf("expected both targets to be picked: %v", counts)
+	}
+}
+
+func TestHostSummaries(t *testing.T) {
+	defer SetTargetHost("http://localhost")
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	ts1 := httptest.NewServer(handler)
+	defer ts1.Close()
+	ts2 := httptest.NewServer(handler)
+	defer ts2.Close()
+
+	if _, err := SetTargetHosts([]string{ts1.URL, ts2.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if !MultipleTargets() {
+		t.Error("expected multiple targets")
+	}
+
+	for range 4 {
+		s := NewSession()
+		req, _ := s.NewRequest("GET", "/", nil)
+		res, err := s.SendRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.Request.URL.Host != s.Target.URL.Host {
+			t.Errorf("expected %s to eq %s", res.Request.URL.Host, s.Target.URL.Host)
+		}
+		s.Success(1)
+	}
+
+	summaries := score.GetHostSummaries()
+	for _, name := range []string{ts1.URL, ts2.URL} {
+		if summaries[name].Success != 2 {
+			t.Errorf("expected %d to eq %d", summaries[name].Success, 2)
+		}
+		if summaries[name].Latency.Count != 2 {
+			t.Errorf("expected %d to eq %d", summaries[name].Latency.Count, 2)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package checker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+)
+
+// TLSOptions は https のターゲットに接続するときの設定
+type TLSOptions struct {
+	// CAFile は PEM 形式の CA 証明書。システムの証明書に追加する
+	CAFile     string
+	Insecure   bool
+	ServerName string
+	HTTP2      bool
+}
+
+var (
+	tlsConfig   *tls.Config
+	enableHTTP2 bool
+	enableH2C   bool
+)
+
+// SetH2C は http のターゲットにも TLS 無しの HTTP/2 (prior knowledge) で接続する
+// HTTP/1.1 には切り替えないので、ターゲットが h2c に対応している必要がある
+func SetH2C(ok bool) {
+	enableH2C = ok
+}
+
+func SetTLSOptions(opts TLSOptions) error {
+	enableHTTP2 = opts.HTTP2
+
+	if opts.CAFile == "" && !opts.Insecure && opts.ServerName == "" {
+		tlsConfig = nil
+		return nil
+	}
+
+	c := &tls.Config{
+		InsecureSkipVerify: opts.Insecure,
+		ServerName:         opts.ServerName,
+	}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("CA証明書が読み込めませんでした")
+		}
+		c.RootCAs = pool
+	}
+
+	tlsConfig = c
+	return nil
+}
+
+// NewTransport はセッションと初期化のリクエストで共通の http.Transport を作る
+func NewTransport(target *Target) *http.Transport {
+	t := &http.Transport{
+		MaxConnsPerHost: maxConnsPerHost,
+		// 転送量を数えるために自分で展開する
+		DisableCompression: true,
+		DialContext:        target.dialContext,
+	}
+
+	if tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig.Clone()
+	}
+
+	// HTTP/2 は指定したときだけ使う
+	t.Protocols = new(http.Protocols)
+	t.Protocols.SetHTTP1(!enableH2C)
+	t.Protocols.SetHTTP2(enableHTTP2 || enableH2C)
+	t.Protocols.SetUnencryptedHTTP2(enableH2C)
+
+	return t
+}
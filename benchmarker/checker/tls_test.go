@@ -0,0 +1,71 @@
+package checker
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/catatsuy/private-isu/benchmarker/score"
+)
+
+func TestTLSOptions(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	defer SetTLSOptions(TLSOptions{})
+
+	if _, err := SetTargetHost(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	os.WriteFile(ca, cert, 0644)
+
+	get := func() (*http.Response, error) {
+		s := NewSession()
+		req, err := s.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.SendRequest(req)
+	}
+
+	if _, err := get(); err == nil {
+		t.Error("expected an unknown CA to fail")
+	}
+
+	if err := SetTLSOptions(TLSOptions{CAFile: ca, ServerName: "example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ProtoMajor != 1 {
+		t.Errorf("expected %d to eq %d", res.ProtoMajor, 1)
+	}
+
+	SetTLSOptions(TLSOptions{Insecure: true, HTTP2: true})
+	res, err = get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ProtoMajor != 2 {
+		t.Errorf("expected %d to eq %d", res.ProtoMajor, 2)
+	}
+
+	if _, ok := score.GetPhaseInstance().Summary()["GET /"][score.PhaseTLS]; !ok {
+		t.Error("expected the TLS handshake to be recorded")
+	}
+
+	if err := SetTLSOptions(TLSOptions{CAFile: filepath.Join(t.TempDir(), "none.pem")}); err == nil {
+		t.Error("expected a missing CA file to fail")
+	}
+}
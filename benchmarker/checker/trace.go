@@ -0,0 +1,149 @@
+package checker
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/score"
+)
+
+// connTrace は1往復の間の接続に関する時刻を記録する
+// ダイヤルは別の goroutine で行われるのでロックする
+type connTrace struct {
+	sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	reused       bool
+}
+
+func traceRequest(req *http.Request) (*http.Request, *connTrace) {
+	c := &connTrace{start: time.Now()}
+	set := func(t *time.Time) {
+		c.Lock()
+		*t = time.Now()
+		c.Unlock()
+	}
+
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { set(&c.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { set(&c.dnsDone) },
+		ConnectStart: func(_, _ string) {
+			// Happy Eyeballs で複数回呼ばれたときは最初のものを使う
+			c.Lock()
+			if c.connectStart.IsZero() {
+				c.connectStart = time.Now()
+			}
+			c.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				set(&c.connectDone)
+			}
+		},
+		TLSHandshakeStart: func() { set(&c.tlsStart) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				set(&c.tlsDone)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			c.Lock()
+			c.gotConn = time.Now()
+			c.reused = info.Reused
+			c.Unlock()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { set(&c.wroteRequest) },
+		GotFirstResponseByte: func() { set(&c.firstByte) },
+	}
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), c
+}
+
+func between(from, to time.Time) (time.Duration, bool) {
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return 0, false
+	}
+	return to.Sub(from), true
+}
+
+func (c *connTrace) record(route, proto string) {
+	c.Lock()
+	defer c.Unlock()
+
+	p := score.GetPhaseInstance()
+	if d, ok := between(c.dnsStart, c.dnsDone); ok {
+		p.Record(route, score.PhaseDNS, d)
+	}
+	if d, ok := between(c.connectStart, c.connectDone); ok {
+		p.Record(route, score.PhaseConnect, d)
+	}
+	if d, ok := between(c.tlsStart, c.tlsDone); ok {
+		p.Record(route, score.PhaseTLS, d)
+	}
+	if d, ok := between(c.start, c.firstByte); ok {
+		p.Record(route, score.PhaseTTFB, d)
+	}
+
+	if !c.gotConn.IsZero() {
+		score.GetConnectionInstance().Record(route, proto, c.reused)
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func msBetween(from, to time.Time) float64 {
+	if d, ok := between(from, to); ok {
+		return ms(d)
+	}
+	return -1
+}
+
+func (c *connTrace) harTimings() harTimings {
+	c.Lock()
+	defer c.Unlock()
+
+	t := harTimings{
+		DNS:     msBetween(c.dnsStart, c.dnsDone),
+		Connect: msBetween(c.connectStart, c.connectDone),
+		SSL:     msBetween(c.tlsStart, c.tlsDone),
+		Send:    max(msBetween(c.gotConn, c.wroteRequest), 0),
+		Wait:    max(msBetween(c.wroteRequest, c.firstByte), 0),
+	}
+	if t.SSL >= 0 {
+		t.Connect = msBetween(c.connectStart, c.tlsDone)
+	}
+
+	// 接続を待っていた時間から、名前解決と接続にかかった時間を除いたもの
+	t.Blocked = -1
+	if waited := msBetween(c.start, c.gotConn); waited >= 0 {
+		t.Blocked = max(waited-max(t.DNS, 0)-max(t.Connect, 0), 0)
+	}
+	return t
+}
+
+// traceTransport はリダイレクトも含めて1往復ごとに記録する
+type traceTransport struct {
+	base http.RoundTripper
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req, c := traceRequest(req)
+	res, err := t.base.RoundTrip(req)
+	if err == nil {
+		c.record(RoutePattern(req.Method, req.URL.Path), res.Proto)
+	}
+	return res, err
+}
@@ -0,0 +1,77 @@
+package checker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/catatsuy/private-isu/benchmarker/score"
+)
+
+func TestConnectionReuse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	defer SetTargetHost("http://localhost")
+	if _, err := SetTargetHost(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSession()
+	for range 3 {
+		req, _ := s.NewRequest("GET", "/connection-reuse", nil)
+		res, err := s.SendRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	// 記録は全体で共有しているので、他のテストが使わないルートで確かめる
+	c := score.GetConnectionInstance().Summary()["GET /connection-reuse"]
+	if c.New != 1 || c.Reused != 2 {
+		t.Errorf("expected new %d, reused %d to eq 1, 2", c.New, c.Reused)
+	}
+	if c.Protocols["HTTP/1.1"] != 3 {
+		t.Errorf("expected %d to eq %d", c.Protocols["HTTP/1.1"], 3)
+	}
+
+	phases := score.GetPhaseInstance().Summary()["GET /connection-reuse"]
+	if phases[score.PhaseConnect].Count != 1 {
+		t.Errorf("expected %d to eq %d", phases[score.PhaseConnect].Count, 1)
+	}
+	if phases[score.PhaseTTFB].Count != 3 {
+		t.Errorf("expected %d to eq %d", phases[score.PhaseTTFB].Count, 3)
+	}
+}
+
+func TestH2C(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetHTTP1(true)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+	defer SetH2C(false)
+
+	defer SetTargetHost("http://localhost")
+	if _, err := SetTargetHost(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	SetH2C(true)
+	s := NewSession()
+	req, _ := s.NewRequest("GET", "/", nil)
+	res, err := s.SendRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.ProtoMajor != 2 {
+		t.Errorf("expected %d to eq %d", res.ProtoMajor, 2)
+	}
+}
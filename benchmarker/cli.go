@@ -7,6 +7,9 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/catatsuy/private-isu/benchmarker/checker"
@@ -23,6 +26,13 @@ const (
 	InitializeTimeout = time.Duration(10) * time.Second
 	BenchmarkTimeout  = 60 * time.Second
 	WaitAfterTimeout  = 10 * time.Second
+	TimelineInterval  = 1 * time.Second
+	StageInterval     = 10 * time.Second
+	SpikeDuration     = 10 * time.Second
+	LatencySLO        = 1 * time.Second
+	WorkerStartDelay  = 3 * time.Second
+	// WorkerTimeoutMargin はワーカーが結果を返すまでに、ベンチマークの時間より余分に待つ時間
+	WorkerTimeoutMargin = 30 * time.Second
 
 	PostsPerPage = 20
 )
@@ -40,11 +50,26 @@ type user struct {
 }
 
 type Output struct {
-	Pass     bool     `json:"pass"`
-	Score    int64    `json:"score"`
-	Suceess  int64    `json:"success"`
-	Fail     int64    `json:"fail"`
-	Messages []string `json:"messages"`
+	Pass        bool                                       `json:"pass"`
+	Score       int64                                      `json:"score"`
+	Suceess     int64                                      `json:"success"`
+	Fail        int64                                      `json:"fail"`
+	Messages    []string                                   `json:"messages"`
+	Failures    []score.FailureGroup                       `json:"failures"`
+	Records     []*score.Failure                           `json:"failure_records,omitempty"`
+	Latencies   map[string]score.LatencySummary            `json:"latencies"`
+	PageLoad    map[string]score.LatencySummary            `json:"page_load"`
+	Cache       score.CacheSummary                         `json:"cache"`
+	Transfer    map[string]score.TransferSummary           `json:"transfer"`
+	Phases      map[string]map[string]score.LatencySummary `json:"phases"`
+	Connections map[string]score.ConnectionSummary         `json:"connections"`
+	Hosts       map[string]score.HostSummary               `json:"hosts,omitempty"`
+	Scenarios   map[string]score.ScenarioSummary           `json:"scenarios"`
+	Timeline    []score.TimelineBucket                     `json:"timeline"`
+	Stages      []StageResult                              `json:"stages,omitempty"`
+	Capacity    *CapacityResult                            `json:"capacity,omitempty"`
+	OpenLoop    *OpenLoopResult                            `json:"open_loop,omitempty"`
+	Replay      *ReplayResult                              `json:"replay,omitempty"`
 }
 
 // Run invokes the CLI with the given arguments.
@@ -56,16 +81,62 @@ func (cli *CLI) Run(args []string) int {
 		benchmarkTimeout time.Duration
 		waitAfterTimeout time.Duration
 
+		timelineInterval time.Duration
+		timelineOut      string
+
+		concurrency           string
+		concurrencyMultiplier float64
+
+		scenarioFile  string
+		scenarioNames string
+		weights       string
+		skipPreflight bool
+
+		stageOptions    loadStageOptions
+		capacityOptions capacityOptions
+		openLoopOptions openLoopOptions
+
+		seed int64
+
+		imageParallel int
+		publicDir     string
+
+		imageVerify    string
+		imageTolerance int
+		imageAccept    string
+
+		acceptEncoding string
+
+		tlsOptions checker.TLSOptions
+		h2c        bool
+		resolve    string
+
+		harPath  string
+		harSplit bool
+
+		replayFile    string
+		replayFormat  string
+		replayOptions replayOptions
+
+		workers      string
+		workerListen string
+		workerToken  string
+		workerPart   string
+		startAt      string
+		workerOutput bool
+
 		version bool
 		debug   bool
+		dumpDir string
 	)
 
 	// Define option flag parse
 	flags := flag.NewFlagSet(Name, flag.ContinueOnError)
 	flags.SetOutput(cli.errStream)
 
-	flags.StringVar(&target, "target", "", "")
+	flags.StringVar(&target, "target", "", "comma separated target URLs (http://host:port, https://host or unix:///path.sock), each optionally followed by =weight")
 	flags.StringVar(&target, "t", "", "(Short)")
+	flags.StringVar(&resolve, "resolve", "", "comma separated host:port:addr to connect to addr instead, like curl --resolve")
 
 	flags.StringVar(&userdata, "userdata", "", "userdata directory")
 	flags.StringVar(&userdata, "u", "", "userdata directory")
@@ -73,10 +144,64 @@ func (cli *CLI) Run(args []string) int {
 	flags.DurationVar(&benchmarkTimeout, "benchmark-timeout", BenchmarkTimeout, "benchmark timeout")
 	flags.DurationVar(&waitAfterTimeout, "wait-after-timeout", WaitAfterTimeout, "wait after timeout")
 
+	flags.StringVar(&concurrency, "concurrency", "", "concurrency per scenario (e.g. \"loadIndex=4,comment=2\") or path to a JSON file")
+	flags.Float64Var(&concurrencyMultiplier, "concurrency-multiplier", 1, "multiplier applied to every scenario concurrency")
+
+	flags.StringVar(&scenarioFile, "scenario-file", "", "JSON file defining extra scenarios")
+	flags.StringVar(&scenarioNames, "scenarios", "", "comma separated scenarios to run in the main loop (default all)")
+	flags.StringVar(&weights, "weights", "", "weight multiplied into each scenario concurrency (e.g. \"comment=3,loadIndex=0.5\"); a weight above 0 keeps at least one worker, so weights below 1 only reduce a concurrency above 1")
+	flags.BoolVar(&skipPreflight, "skip-preflight", false, "skip the checks run before the main loop")
+
+	flags.StringVar(&stageOptions.Mode, "load-mode", LoadModeConstant, "how concurrency changes over time: constant, linear, step, spike, adaptive or open")
+	flags.DurationVar(&stageOptions.StageInterval, "stage-interval", StageInterval, "length of each stage in linear, step and adaptive mode")
+	flags.Float64Var(&stageOptions.StepMultiplier, "step-multiplier", 1, "concurrency multiplier added at every stage in step and adaptive mode")
+	flags.Float64Var(&stageOptions.SpikeMultiplier, "spike-multiplier", 5, "concurrency multiplier during the spike in spike mode")
+	flags.DurationVar(&stageOptions.SpikeDuration, "spike-duration", SpikeDuration, "length of the spike in spike mode")
+	flags.Float64Var(&capacityOptions.MaxFailRate, "max-fail-rate", 0.01, "highest fail rate regarded as sustainable in adaptive mode")
+	flags.DurationVar(&capacityOptions.LatencySLO, "latency-slo", LatencySLO, "highest p99 latency regarded as sustainable in adaptive mode")
+	flags.Float64Var(&openLoopOptions.Rate, "rate", 10, "scenarios started per second in open mode")
+	flags.IntVar(&openLoopOptions.MaxInFlight, "max-in-flight", 1000, "scenarios running at once in open mode before new starts are dropped")
+
+	flags.DurationVar(&timelineInterval, "timeline-interval", TimelineInterval, "timeline bucket size")
+	flags.StringVar(&timelineOut, "timeline-out", "", "write timeline buckets as NDJSON while running (\"-\" for stderr)")
+
+	flags.StringVar(&replayFile, "replay", "", "replay requests recorded in an nginx access log or HAR file instead of running the scenarios")
+	flags.StringVar(&replayFormat, "replay-format", ReplayFormatAuto, "format of -replay: auto, nginx, ltsv or har")
+	flags.Float64Var(&replayOptions.Speed, "replay-speed", 1, "speed-up factor applied to the recorded timing")
+
+	flags.StringVar(&harPath, "har", "", "record every request and response to this HAR file")
+	flags.BoolVar(&harSplit, "har-per-scenario", false, "write one HAR file per scenario next to -har")
+
+	flags.StringVar(&publicDir, "public-dir", "", "directory of static files used to verify the assets a page refers to (default webapp/public next to -userdata)")
+	flags.IntVar(&imageParallel, "image-parallelism", 1, "images fetched at once per session, like the per-host connection limit of a browser")
+
+	flags.StringVar(&imageVerify, "image-verify", checker.ImageVerifyMD5, "how uploaded images are verified: md5 or decode (format, size and perceptual hash)")
+	flags.IntVar(&imageTolerance, "image-tolerance", 4, "max hamming distance of the perceptual hash in decode mode (0-64)")
+
+	flags.StringVar(&imageAccept, "image-accept", "", "Accept header sent with image requests (e.g. \"image/webp,*/*\"); formats listed here are verified by decoding")
+
+	flags.StringVar(&acceptEncoding, "accept-encoding", "gzip", "Accept-Encoding header sent with every request (e.g. \"br, zstd, gzip\"); empty to send none")
+
+	flags.StringVar(&tlsOptions.CAFile, "tls-ca", "", "PEM file of CA certificates trusted in addition to the system ones")
+	flags.BoolVar(&tlsOptions.Insecure, "tls-insecure", false, "skip TLS certificate verification")
+	flags.StringVar(&tlsOptions.ServerName, "tls-server-name", "", "server name sent as SNI and used to verify the certificate")
+	flags.BoolVar(&tlsOptions.HTTP2, "http2", false, "use HTTP/2 when the target supports it")
+	flags.BoolVar(&h2c, "h2c", false, "use HTTP/2 without TLS (prior knowledge) for http:// targets")
+
+	flags.StringVar(&workers, "workers", "", "comma separated worker URLs (e.g. \"http://127.0.0.1:7001,http://127.0.0.1:7002\") to split the load across; workers must be started with the same target, userdata, timeouts, scenario file and static files")
+	flags.StringVar(&workerListen, "worker-listen", "", "run as a worker on this address (e.g. 127.0.0.1:7001), using the other flags for every run")
+	flags.StringVar(&workerToken, "worker-token", "", "token shared by the coordinator and workers; required for a worker listening on a non-loopback address")
+	flags.StringVar(&workerPart, "worker-part", "", "(internal) i/n part of the userdata used by a worker")
+	flags.StringVar(&startAt, "start-at", "", "(internal) time the main loop starts at")
+	flags.BoolVar(&workerOutput, "worker-output", false, "(internal) print raw results for the coordinator")
+
+	flags.Int64Var(&seed, "seed", 0, "seed for the random choices of each scenario worker (0 means random)")
+
 	flags.BoolVar(&version, "version", false, "Print version information and quit.")
 
 	flags.BoolVar(&debug, "debug", false, "Debug mode")
 	flags.BoolVar(&debug, "d", false, "Debug mode")
+	flags.StringVar(&dumpDir, "dump-dir", "", "save failed requests and responses to this directory (debug mode only)")
 
 	// Parse commandline flag
 	if err := flags.Parse(args[1:]); err != nil {
@@ -89,15 +214,195 @@ func (cli *CLI) Run(args []string) int {
 		return ExitCodeOK
 	}
 
-	targetHost, err := checker.SetTargetHost(target)
+	var definitions []*scenarioDefinition
+	if scenarioFile != "" {
+		var err error
+		definitions, err = loadScenarioFile(scenarioFile)
+		if err != nil {
+			fmt.Fprintln(cli.errStream, err)
+			return ExitCodeError
+		}
+	}
+
+	m, err := newAssetManifest(publicDir, userdata)
+	if err != nil {
+		fmt.Fprintf(cli.errStream, "静的ファイルを読み込めませんでした: %s\n", err)
+		return ExitCodeError
+	}
+	manifest = m
+
+	if workerListen != "" {
+		// コーディネーターは -replay と一緒に使えないので、ワーカーも受け付けない
+		if replayFile != "" {
+			fmt.Fprintln(cli.errStream, "worker-listen cannot be used with replay")
+			return ExitCodeError
+		}
+		return serveWorker(workerListen, workerToken, workerArgs(flags), newWorkerSettings(definitions, manifest), cli.errStream)
+	}
+
+	defaultProfile := defaultConcurrencyProfile()
+	for _, def := range definitions {
+		defaultProfile[def.Name] = *def.Concurrency
+	}
+
+	baseProfile, err := parseConcurrencyProfile(concurrency, defaultProfile)
+	if err != nil {
+		fmt.Fprintln(cli.errStream, err)
+		return ExitCodeError
+	}
+	if concurrencyMultiplier <= 0 {
+		fmt.Fprintln(cli.errStream, "concurrency-multiplier must be positive")
+		return ExitCodeError
+	}
+	scenarioWeights, err := parseScenarioWeights(weights, scenarioNames, baseProfile)
+	if err != nil {
+		fmt.Fprintln(cli.errStream, err)
+		return ExitCodeError
+	}
+	profile := baseProfile.Scale(concurrencyMultiplier).Weight(scenarioWeights)
+
+	var stages []loadStage
+	switch stageOptions.Mode {
+	case LoadModeAdaptive:
+		if stageOptions.StageInterval <= 0 || stageOptions.StageInterval > benchmarkTimeout {
+			fmt.Fprintln(cli.errStream, "stage-interval must be between 0 and benchmark-timeout")
+			return ExitCodeError
+		}
+		if stageOptions.StepMultiplier <= 0 {
+			fmt.Fprintln(cli.errStream, "step-multiplier must be positive in adaptive mode")
+			return ExitCodeError
+		}
+	case LoadModeOpen:
+		if openLoopOptions.Rate <= 0 || openLoopOptions.MaxInFlight <= 0 {
+			fmt.Fprintln(cli.errStream, "rate and max-in-flight must be positive in open mode")
+			return ExitCodeError
+		}
+	default:
+		stages, err = buildLoadStages(benchmarkTimeout, stageOptions)
+		if err != nil {
+			fmt.Fprintln(cli.errStream, err)
+			return ExitCodeError
+		}
+	}
+
+	workerURLs := parseWorkerURLs(workers)
+	if len(workerURLs) > 0 {
+		if replayFile != "" || stageOptions.Mode == LoadModeAdaptive || stageOptions.Mode == LoadModeOpen {
+			fmt.Fprintln(cli.errStream, "workers cannot be used with replay, adaptive or open mode")
+			return ExitCodeError
+		}
+		if timelineOut != "" {
+			fmt.Fprintln(cli.errStream, "workers cannot be used with timeline-out")
+			return ExitCodeError
+		}
+	}
+
+	part, parts := 0, 1
+	if workerPart != "" {
+		part, parts, err = parseWorkerPart(workerPart)
+		if err != nil {
+			fmt.Fprintln(cli.errStream, err)
+			return ExitCodeError
+		}
+	}
+
+	var startTime time.Time
+	if startAt != "" {
+		startTime, err = time.Parse(time.RFC3339Nano, startAt)
+		if err != nil {
+			fmt.Fprintf(cli.errStream, "invalid start-at: %s\n", err)
+			return ExitCodeError
+		}
+	}
+
+	if dumpDir != "" {
+		if !debug {
+			fmt.Fprintln(cli.errStream, "dump-dir is only available in debug mode")
+			return ExitCodeError
+		}
+		if err := checker.SetDumpDir(dumpDir); err != nil {
+			fmt.Fprintln(cli.errStream, err)
+			return ExitCodeError
+		}
+	}
+
+	if imageParallel <= 0 {
+		fmt.Fprintln(cli.errStream, "image-parallelism must be positive")
+		return ExitCodeError
+	}
+	imageParallelism = imageParallel
+	if imageParallel > 1 {
+		checker.SetMaxConnsPerHost(imageParallel)
+	}
+
+	if imageVerify != checker.ImageVerifyMD5 && imageVerify != checker.ImageVerifyDecode {
+		fmt.Fprintln(cli.errStream, "image-verify must be md5 or decode")
+		return ExitCodeError
+	}
+	if imageTolerance < 0 || imageTolerance > 64 {
+		fmt.Fprintln(cli.errStream, "image-tolerance must be between 0 and 64")
+		return ExitCodeError
+	}
+	checker.SetImageVerify(imageVerify, imageTolerance)
+	if err := checker.SetImageAccept(imageAccept); err != nil {
+		fmt.Fprintln(cli.errStream, err)
+		return ExitCodeError
+	}
+	checker.SetAcceptEncoding(acceptEncoding)
+
+	if err := checker.SetTLSOptions(tlsOptions); err != nil {
+		fmt.Fprintln(cli.errStream, err)
+		return ExitCodeError
+	}
+	checker.SetH2C(h2c)
+
+	if err := checker.SetResolve(strings.Split(resolve, ",")); err != nil {
+		fmt.Fprintln(cli.errStream, err)
+		return ExitCodeError
+	}
+
+	if harPath != "" {
+		checker.EnableHAR()
+		// 初期化やプリフライトで失敗したときの記録こそ必要なので、どこで終わっても書き出す
+		defer func() {
+			if err := checker.WriteHAR(harPath, harSplit); err != nil {
+				fmt.Fprintln(cli.errStream, err)
+			}
+		}()
+	}
+
+	var replayRequests []*replayRequest
+	if replayFile != "" {
+		if replayOptions.Speed <= 0 {
+			fmt.Fprintln(cli.errStream, "replay-speed must be positive")
+			return ExitCodeError
+		}
+		replayRequests, err = loadReplayRequests(replayFile, replayFormat)
+		if err != nil {
+			fmt.Fprintln(cli.errStream, err)
+			return ExitCodeError
+		}
+	}
+
+	if timelineInterval <= 0 {
+		fmt.Fprintln(cli.errStream, "timeline-interval must be positive")
+		return ExitCodeError
+	}
+
+	targets, err := checker.SetTargetHosts(strings.Split(target, ","))
 	if err != nil {
 		outputNeedToContactUs(err.Error())
 		return ExitCodeError
 	}
 
-	initialize := make(chan bool)
+	initialize := make(chan bool, 1)
 
-	setupInitialize(targetHost, initialize)
+	if workerOutput {
+		// 初期化はコーディネーターが済ませている
+		initialize <- true
+	} else {
+		setupInitialize(targets, initialize)
+	}
 
 	users, _, adminUsers, sentences, images, err := prepareUserdata(userdata)
 	if err != nil {
@@ -105,6 +410,22 @@ func (cli *CLI) Run(args []string) int {
 		return ExitCodeError
 	}
 
+	if parts > 1 {
+		users = partitionUsers(users, part, parts)
+		// 管理者は少ないので足りなければ分けない
+		if len(adminUsers) >= parts {
+			adminUsers = partitionUsers(adminUsers, part, parts)
+		}
+	}
+
+	if imageVerify == checker.ImageVerifyDecode || imageAccept != "" {
+		err = prepareImageFingerprints(images)
+		if err != nil {
+			outputNeedToContactUs(err.Error())
+			return ExitCodeError
+		}
+	}
+
 	initReq := <-initialize
 
 	if !initReq {
@@ -113,79 +434,74 @@ func (cli *CLI) Run(args []string) int {
 		return ExitCodeError
 	}
 
-	// 最初にDOMチェックなどをやってしまい、通らなければさっさと失敗させる
-	commentScenario(checker.NewSession(), randomUser(users), randomUser(users).AccountName, randomSentence(sentences))
-	postImageScenario(checker.NewSession(), randomUser(users), randomImage(images), randomSentence(sentences))
-	cannotLoginNonexistentUserScenario(checker.NewSession())
-	cannotLoginWrongPasswordScenario(checker.NewSession(), randomUser(users))
-	cannotAccessAdminScenario(checker.NewSession(), randomUser(users))
-	cannotPostWrongCSRFTokenScenario(checker.NewSession(), randomUser(users), randomImage(images))
-	loginScenario(checker.NewSession(), randomUser(users))
-	banScenario(checker.NewSession(), checker.NewSession(), randomUser(users), randomUser(adminUsers), randomImage(images), randomSentence(sentences))
+	time.Sleep(time.Until(startTime))
 
-	if score.GetInstance().GetFails() > 0 {
-		fmt.Println(outputResultJSON(false, score.GetFailErrorsStringSlice()))
-		return ExitCodeError
+	score.GetTimelineInstance().Start(timelineInterval)
+	if timelineOut != "" {
+		stopTimeline, err := startTimelineWriter(timelineOut, cli.errStream, timelineInterval)
+		if err != nil {
+			outputNeedToContactUs(err.Error())
+			return ExitCodeError
+		}
+		defer stopTimeline()
 	}
 
-	indexMoreAndMoreScenarioCh := makeChanBool(2)
-	loadIndexScenarioCh := makeChanBool(2)
-	userAndPostPageScenarioCh := makeChanBool(2)
-	commentScenarioCh := makeChanBool(1)
-	postImageScenarioCh := makeChanBool(1)
-	loginScenarioCh := makeChanBool(2)
-	banScenarioCh := makeChanBool(1)
+	if !skipPreflight {
+		r := newRand(seed, "preflight", 0)
+
+		// 最初にDOMチェックなどをやってしまい、通らなければさっさと失敗させる
+		commentScenario(newSession("comment", r), randomUser(r, users), randomUser(r, users).AccountName, randomSentence(r, sentences))
+		postImageScenario(newSession("postImage", r), randomUser(r, users), randomImage(r, images), randomSentence(r, sentences))
+		cannotLoginNonexistentUserScenario(newSession("login", r))
+		cannotLoginWrongPasswordScenario(newSession("login", r), randomUser(r, users))
+		cannotAccessAdminScenario(newSession("ban", r), randomUser(r, users))
+		cannotPostWrongCSRFTokenScenario(newSession("postImage", r), randomUser(r, users), randomImage(r, images))
+		loginScenario(newSession("login", r), randomUser(r, users))
+		banScenario(newSession("ban", r), newSession("ban", r), randomUser(r, users), randomUser(r, adminUsers), randomImage(r, images), randomSentence(r, sentences))
+
+		if score.GetInstance().GetFails() > 0 {
+			fmt.Println(outputResultJSON(false, score.GetFailErrorsStringSlice()))
+			return ExitCodeError
+		}
+	}
 
-	timeoutCh := time.After(benchmarkTimeout)
+	scenarios := newMainScenarios(users, adminUsers, sentences, images)
+	for _, def := range definitions {
+		scenarios = append(scenarios, newDeclarativeScenario(def, users, adminUsers, sentences, images))
+	}
 
-L:
-	for {
-		select {
-		case <-indexMoreAndMoreScenarioCh:
-			go func() {
-				indexMoreAndMoreScenario(checker.NewSession())
-				indexMoreAndMoreScenarioCh <- true
-			}()
-		case <-loadIndexScenarioCh:
-			go func() {
-				loadIndexScenario(checker.NewSession())
-				loadIndexScenarioCh <- true
-			}()
-		case <-userAndPostPageScenarioCh:
-			go func() {
-				userAndPostPageScenario(checker.NewSession(), randomUser(users).AccountName)
-				userAndPostPageScenarioCh <- true
-			}()
-		case <-commentScenarioCh:
-			go func() {
-				commentScenario(checker.NewSession(), randomUser(users), randomUser(users).AccountName, randomSentence(sentences))
-				commentScenarioCh <- true
-			}()
-		case <-postImageScenarioCh:
-			go func() {
-				postImageScenario(checker.NewSession(), randomUser(users), randomImage(images), randomSentence(sentences))
-				cannotPostWrongCSRFTokenScenario(checker.NewSession(), randomUser(users), randomImage(images))
-				postImageScenarioCh <- true
-			}()
-		case <-loginScenarioCh:
-			go func() {
-				loginScenario(checker.NewSession(), randomUser(users))
-				cannotLoginNonexistentUserScenario(checker.NewSession())
-				cannotLoginWrongPasswordScenario(checker.NewSession(), randomUser(users))
-				loginScenarioCh <- true
-			}()
-		case <-banScenarioCh:
-			go func() {
-				banScenario(checker.NewSession(), checker.NewSession(), randomUser(users), randomUser(adminUsers), randomImage(images), randomSentence(sentences))
-				cannotAccessAdminScenario(checker.NewSession(), randomUser(users))
-				banScenarioCh <- true
-			}()
-		case <-timeoutCh:
-			break L
+	var stageResults []StageResult
+	var capacity *CapacityResult
+	var openLoop *OpenLoopResult
+	var replay *ReplayResult
+	switch {
+	case len(workerURLs) > 0:
+		base := workerRequest{Seed: seed, Load: stageOptions, Settings: newWorkerSettings(definitions, manifest)}
+		stageResults, err = runCoordinator(workerURLs, workerToken, profile, base, benchmarkTimeout+waitAfterTimeout)
+		if err != nil {
+			outputNeedToContactUs(err.Error())
+			return ExitCodeError
 		}
+	case replayRequests != nil:
+		replay = runReplay(replayRequests, benchmarkTimeout, replayOptions, seed, users, sentences, images)
+	case stageOptions.Mode == LoadModeAdaptive:
+		stageResults, capacity = runCapacitySearch(newScenarioPools(scenarios, seed), profile, benchmarkTimeout, stageOptions, capacityOptions)
+	case stageOptions.Mode == LoadModeOpen:
+		openLoop = runOpenLoop(scenarios, profile, benchmarkTimeout, openLoopOptions, seed)
+	default:
+		stageResults = runLoadStages(newScenarioPools(scenarios, seed), profile, stages)
+	}
+
+	// ワーカーが待ってから返してくるので待たなくてよい
+	if len(workerURLs) == 0 {
+		time.Sleep(waitAfterTimeout)
 	}
 
-	time.Sleep(waitAfterTimeout)
+	if workerOutput {
+		b, _ := json.Marshal(collectWorkerResult(stageResults))
+		fmt.Println(string(b))
+		return ExitCodeOK
+	}
 
 	var msgs []string
 	if !debug {
@@ -194,21 +510,51 @@ L:
 		msgs = score.GetFailRawErrorsStringSlice()
 	}
 
-	fmt.Println(outputResultJSON(true, msgs))
+	output := newOutput(true, msgs)
+	if debug {
+		output.Records = score.GetFailures()
+	}
+	if stageResults != nil && (stageOptions.Mode == LoadModeAdaptive || len(stages) > 1) {
+		output.Stages = stageResults
+	}
+	output.Capacity = capacity
+	output.OpenLoop = openLoop
+	output.Replay = replay
+
+	b, _ := json.Marshal(output)
+	fmt.Println(string(b))
 
 	return ExitCodeOK
 }
 
-func outputResultJSON(pass bool, messages []string) string {
-	output := Output{
-		Pass:     pass,
-		Score:    score.GetInstance().GetScore(),
-		Suceess:  score.GetInstance().GetSucesses(),
-		Fail:     score.GetInstance().GetFails(),
-		Messages: messages,
+func newOutput(pass bool, messages []string) Output {
+	o := Output{
+		Pass:        pass,
+		Score:       score.GetInstance().GetScore(),
+		Suceess:     score.GetInstance().GetSucesses(),
+		Fail:        score.GetInstance().GetFails(),
+		Messages:    messages,
+		Failures:    score.GetFailureGroups(),
+		Latencies:   score.GetLatencyInstance().Summary(),
+		PageLoad:    score.GetPageLoadInstance().Summary(),
+		Cache:       score.GetCacheInstance().Summary(),
+		Transfer:    score.GetTransferInstance().Summary(),
+		Phases:      score.GetPhaseInstance().Summary(),
+		Connections: score.GetConnectionInstance().Summary(),
+		Scenarios:   score.GetScenarioSummaries(),
+		Timeline:    score.GetTimelineInstance().Buckets(),
 	}
 
-	b, _ := json.Marshal(output)
+	// 1台だけのときは全体の値と同じなので出さない
+	if checker.MultipleTargets() {
+		o.Hosts = score.GetHostSummaries()
+	}
+
+	return o
+}
+
+func outputResultJSON(pass bool, messages []string) string {
+	b, _ := json.Marshal(newOutput(pass, messages))
 
 	return string(b)
 }
@@ -218,51 +564,62 @@ func outputNeedToContactUs(message string) {
 	fmt.Println(outputResultJSON(false, []string{"！！！主催者に連絡してください！！！", message}))
 }
 
-func makeChanBool(len int) chan bool {
-	ch := make(chan bool, len)
-	for range len {
-		ch <- true
-	}
-	return ch
+func randomUser(r *util.Rand, users []user) user {
+	return users[r.Number(len(users))]
 }
 
-func randomUser(users []user) user {
-	return users[util.RandomNumber(len(users))]
+func randomImage(r *util.Rand, images []*checker.Asset) *checker.Asset {
+	return images[r.Number(len(images))]
 }
 
-func randomImage(images []*checker.Asset) *checker.Asset {
-	return images[util.RandomNumber(len(images))]
+func randomSentence(r *util.Rand, sentences []string) string {
+	return sentences[r.Number(len(sentences))]
 }
 
-func randomSentence(sentences []string) string {
-	return sentences[util.RandomNumber(len(sentences))]
-}
+// 複数台のときはすべてのホストで初期化する
+func setupInitialize(targets []*checker.Target, initialize chan bool) {
+	go func(targets []*checker.Target) {
+		var wg sync.WaitGroup
+		var failed atomic.Bool
 
-func setupInitialize(targetHost *url.URL, initialize chan bool) {
-	go func(targetHost *url.URL) {
-		client := &http.Client{
-			Timeout: InitializeTimeout,
+		for _, target := range targets {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if !requestInitialize(target) {
+					failed.Store(true)
+				}
+			}()
 		}
 
-		parsedURL := &url.URL{
-			Scheme: targetHost.Scheme,
-			Host:   targetHost.Host,
-			Path:   "/initialize",
-		}
-		req, err := http.NewRequest("GET", parsedURL.String(), nil)
-		if err != nil {
-			return
-		}
+		wg.Wait()
+		initialize <- !failed.Load()
+	}(targets)
+}
+
+func requestInitialize(target *checker.Target) bool {
+	client := &http.Client{
+		Transport: checker.NewTransport(target),
+		Timeout:   InitializeTimeout,
+	}
 
-		req.Header.Set("User-Agent", checker.UserAgent)
+	parsedURL := &url.URL{
+		Scheme: target.URL.Scheme,
+		Host:   target.URL.Host,
+		Path:   "/initialize",
+	}
+	req, err := http.NewRequest("GET", parsedURL.String(), nil)
+	if err != nil {
+		return false
+	}
 
-		res, err := client.Do(req)
+	req.Header.Set("User-Agent", checker.UserAgent)
 
-		if err != nil {
-			initialize <- false
-			return
-		}
-		defer res.Body.Close()
-		initialize <- true
-	}(targetHost)
+	res, err := client.Do(req)
+
+	if err != nil {
+		return false
+	}
+	defer res.Body.Close()
+	return true
 }
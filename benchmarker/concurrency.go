@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// シナリオごとの並列数。指定されなかったシナリオはデフォルトのまま
+type concurrencyProfile map[string]int
+
+func defaultConcurrencyProfile() concurrencyProfile {
+	return concurrencyProfile{
+		"indexMoreAndMore": 2,
+		"loadIndex":        2,
+		"userAndPostPage":  2,
+		"comment":          1,
+		"postImage":        1,
+		"login":            2,
+		"ban":              1,
+	}
+}
+
+// parseConcurrencyProfile は "loadIndex=4,comment=2" 形式か、
+// {"loadIndex": 4, "comment": 2} のようなJSONファイルのパスを受け付ける
+// base に無いシナリオ名はエラーにする
+func parseConcurrencyProfile(value string, base concurrencyProfile) (concurrencyProfile, error) {
+	profile := maps.Clone(base)
+	if value == "" {
+		return profile, nil
+	}
+
+	overrides := map[string]int{}
+
+	if strings.Contains(value, "=") {
+		for _, kv := range strings.Split(value, ",") {
+			name, n, ok := strings.Cut(strings.TrimSpace(kv), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid concurrency: %q", kv)
+			}
+			c, err := strconv.Atoi(n)
+			if err != nil {
+				return nil, fmt.Errorf("invalid concurrency: %q", kv)
+			}
+			overrides[name] = c
+		}
+	} else {
+		b, err := os.ReadFile(value)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &overrides); err != nil {
+			return nil, fmt.Errorf("invalid concurrency file %s: %w", value, err)
+		}
+	}
+
+	for name, c := range overrides {
+		if _, ok := profile[name]; !ok {
+			return nil, fmt.Errorf("unknown scenario: %s", name)
+		}
+		if c < 0 {
+			return nil, fmt.Errorf("concurrency of %s must not be negative", name)
+		}
+		profile[name] = c
+	}
+
+	return profile, nil
+}
+
+// Scale は全シナリオの並列数に倍率を掛ける。0でなければ最低1は残す
+func (p concurrencyProfile) Scale(multiplier float64) concurrencyProfile {
+	scaled := make(concurrencyProfile, len(p))
+	for name, c := range p {
+		n := int(math.Round(float64(c) * multiplier))
+		if c > 0 && n < 1 {
+			n = 1
+		}
+		scaled[name] = n
+	}
+	return scaled
+}
+
+// parseScenarioWeights は "comment=3,ban=0.5" 形式の重みを読む
+// selected が空でなければそこに含まれないシナリオの重みは0にする
+func parseScenarioWeights(value string, selected string, profile concurrencyProfile) (map[string]float64, error) {
+	weights := make(map[string]float64, len(profile))
+	for name := range profile {
+		weights[name] = 1
+	}
+
+	if value != "" {
+		for _, kv := range strings.Split(value, ",") {
+			name, w, ok := strings.Cut(strings.TrimSpace(kv), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid weight: %q", kv)
+			}
+			f, err := strconv.ParseFloat(w, 64)
+			if err != nil || f < 0 {
+				return nil, fmt.Errorf("invalid weight: %q", kv)
+			}
+			if _, ok := profile[name]; !ok {
+				return nil, fmt.Errorf("unknown scenario: %s", name)
+			}
+			weights[name] = f
+		}
+	}
+
+	if selected != "" {
+		names := map[string]bool{}
+		for _, name := range strings.Split(selected, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := profile[name]; !ok {
+				return nil, fmt.Errorf("unknown scenario: %s", name)
+			}
+			names[name] = true
+		}
+		for name := range weights {
+			if !names[name] {
+				weights[name] = 0
+			}
+		}
+	}
+
+	return weights, nil
+}
+
+// Weight はシナリオごとの並列数に重みを掛ける。重みが0なら止める
+// 0より大きければ最低1は残すので、並列数1のシナリオに1未満の重みを付けても変わらない
+func (p concurrencyProfile) Weight(weights map[string]float64) concurrencyProfile {
+	weighted := make(concurrencyProfile, len(p))
+	for name, c := range p {
+		w := weights[name]
+		n := int(math.Round(float64(c) * w))
+		if c > 0 && w > 0 && n < 1 {
+			n = 1
+		}
+		weighted[name] = n
+	}
+	return weighted
+}
+
+// String は -concurrency に渡せる形式にする
+func (p concurrencyProfile) String() string {
+	kvs := make([]string, 0, len(p))
+	for _, name := range slices.Sorted(maps.Keys(p)) {
+		kvs = append(kvs, fmt.Sprintf("%s=%d", name, p[name]))
+	}
+	return strings.Join(kvs, ",")
+}
+
+// Split は並列数を n 台に分ける。割り切れない分は同じ台に偏らないようにずらして配る
+func (p concurrencyProfile) Split(n int) []concurrencyProfile {
+	parts := make([]concurrencyProfile, n)
+	for i := range parts {
+		parts[i] = make(concurrencyProfile, len(p))
+	}
+
+	offset := 0
+	for _, name := range slices.Sorted(maps.Keys(p)) {
+		c := p[name]
+		for i := range parts {
+			parts[i][name] = c / n
+		}
+		for j := range c % n {
+			parts[(offset+j)%n][name]++
+		}
+		offset += c % n
+	}
+	return parts
+}
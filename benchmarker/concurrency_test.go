@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseConcurrencyProfile(t *testing.T) {
+	profile, err := parseConcurrencyProfile("loadIndex=4, comment=0", defaultConcurrencyProfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if profile["loadIndex"] != 4 {
+		t.Errorf("expected %d to eq %d", profile["loadIndex"], 4)
+	}
+	if profile["comment"] != 0 {
+		t.Errorf("expected %d to eq %d", profile["comment"], 0)
+	}
+	if profile["ban"] != 1 {
+		t.Errorf("expected %d to eq %d", profile["ban"], 1)
+	}
+
+	if _, err := parseConcurrencyProfile("unknown=1", defaultConcurrencyProfile()); err == nil {
+		t.Error("expected error for unknown scenario")
+	}
+	if _, err := parseConcurrencyProfile("ban=-1", defaultConcurrencyProfile()); err == nil {
+		t.Error("expected error for negative concurrency")
+	}
+}
+
+func TestParseConcurrencyProfile_file(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.json")
+	if err := os.WriteFile(path, []byte(`{"postImage": 3}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	profile, err := parseConcurrencyProfile(path, defaultConcurrencyProfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile["postImage"] != 3 {
+		t.Errorf("expected %d to eq %d", profile["postImage"], 3)
+	}
+}
+
+func TestConcurrencyProfileScale(t *testing.T) {
+	profile := concurrencyProfile{"loadIndex": 2, "ban": 1, "comment": 0}.Scale(0.25)
+
+	if profile["loadIndex"] != 1 {
+		t.Errorf("expected %d to eq %d", profile["loadIndex"], 1)
+	}
+	if profile["ban"] != 1 {
+		t.Errorf("expected %d to eq %d", profile["ban"], 1)
+	}
+	if profile["comment"] != 0 {
+		t.Errorf("expected %d to eq %d", profile["comment"], 0)
+	}
+}
+
+func TestParseScenarioWeights(t *testing.T) {
+	profile := defaultConcurrencyProfile()
+
+	weights, err := parseScenarioWeights("comment=3,login=0.5,ban=0.5", "comment,login,ban", profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	weighted := profile.Weight(weights)
+	if weighted["comment"] != 3 {
+		t.Errorf("expected %d to eq %d", weighted["comment"], 3)
+	}
+	if weighted["login"] != 1 {
+		t.Errorf("expected %d to eq %d", weighted["login"], 1)
+	}
+	// 並列数1のシナリオは止めない限り1のまま
+	if weighted["ban"] != 1 {
+		t.Errorf("expected %d to eq %d", weighted["ban"], 1)
+	}
+	if weighted["loadIndex"] != 0 {
+		t.Errorf("expected %d to eq %d", weighted["loadIndex"], 0)
+	}
+
+	if _, err := parseScenarioWeights("", "comment,unknown", profile); err == nil {
+		t.Error("expected error for unknown scenario")
+	}
+}
+
+func TestConcurrencyProfileSplit(t *testing.T) {
+	profile := concurrencyProfile{"loadIndex": 5, "comment": 1, "ban": 1}
+	parts := profile.Split(2)
+
+	for name, c := range profile {
+		total := 0
+		for _, p := range parts {
+			total += p[name]
+		}
+		if total != c {
+			t.Errorf("%s: expected %d to eq %d", name, total, c)
+		}
+	}
+
+	// 余りの1は別々のワーカーに配る
+	if parts[0]["ban"]+parts[0]["comment"] != 1 {
+		t.Errorf("expected %d to eq %d", parts[0]["ban"]+parts[0]["comment"], 1)
+	}
+
+	if s := profile.String(); s != "ban=1,comment=1,loadIndex=5" {
+		t.Errorf("expected %s to eq %s", s, "ban=1,comment=1,loadIndex=5")
+	}
+}
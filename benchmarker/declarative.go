@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+// ファイルで定義された追加シナリオ
+//
+//	{
+//	  "scenarios": [{
+//	    "name": "myComment",
+//	    "concurrency": 1,
+//	    "steps": [
+//	      {"method": "POST", "path": "/login", "expected_location": "^/$",
+//	       "form": {"account_name": "{{account_name}}", "password": "{{password}}"},
+//	       "captures": {"csrf_token": {"selector": "input[name=\"csrf_token\"]", "attr": "value"}}},
+//	      ...
+//	    ]
+//	  }]
+//	}
+//
+// path, form, expected_location, assertionのtextでは {{name}} で
+// captureした値と account_name, password, other_account_name,
+// admin_account_name, admin_password, sentence, random が使える
+// expected_location に入れた値は正規表現ではなく文字列として一致させる
+// concurrency を省略したときは1、0なら -concurrency で指定しない限り動かさない
+type scenarioFile struct {
+	Scenarios []*scenarioDefinition `json:"scenarios"`
+}
+
+type scenarioDefinition struct {
+	Name        string            `json:"name"`
+	Concurrency *int              `json:"concurrency"`
+	Steps       []*stepDefinition `json:"steps"`
+}
+
+type stepDefinition struct {
+	Description      string                        `json:"description"`
+	Method           string                        `json:"method"`
+	Path             string                        `json:"path"`
+	Form             map[string]string             `json:"form"`
+	Upload           string                        `json:"upload"`
+	ExpectedStatus   int                           `json:"expected_status"`
+	ExpectedLocation string                        `json:"expected_location"`
+	Assertions       []*assertionDefinition        `json:"assertions"`
+	Captures         map[string]*captureDefinition `json:"captures"`
+}
+
+// assertionDefinition はselectorに一致する要素が min_count 個以上あることを確認する
+// absent なら1つも無いこと、text があれば最初の要素のテキストが一致することを確認する
+type assertionDefinition struct {
+	Selector string `json:"selector"`
+	MinCount int    `json:"min_count"`
+	Text     string `json:"text"`
+	Absent   bool   `json:"absent"`
+}
+
+// captureDefinition はselectorに一致する最初の要素のattr(空ならテキスト)を取り出す
+type captureDefinition struct {
+	Selector string `json:"selector"`
+	Attr     string `json:"attr"`
+}
+
+var templateRe = regexp.MustCompile(`\{\{\s*([\w.]+)\s*\}\}`)
+
+func loadScenarioFile(path string) ([]*scenarioDefinition, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f scenarioFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("invalid scenario file %s: %w", path, err)
+	}
+
+	builtin := defaultConcurrencyProfile()
+	seen := map[string]bool{}
+	for _, def := range f.Scenarios {
+		if def.Name == "" {
+			return nil, errors.New("scenario name is empty")
+		}
+		if _, ok := builtin[def.Name]; ok || seen[def.Name] {
+			return nil, fmt.Errorf("duplicate scenario name: %s", def.Name)
+		}
+		seen[def.Name] = true
+
+		if def.Concurrency == nil {
+			def.Concurrency = new(int)
+			*def.Concurrency = 1
+		}
+		if *def.Concurrency < 0 {
+			return nil, fmt.Errorf("concurrency of %s must not be negative", def.Name)
+		}
+
+		if len(def.Steps) == 0 {
+			return nil, fmt.Errorf("scenario %s has no steps", def.Name)
+		}
+		for i, step := range def.Steps {
+			if step.Method == "" || step.Path == "" {
+				return nil, fmt.Errorf("step %d of %s needs method and path", i+1, def.Name)
+			}
+			step.Method = strings.ToUpper(step.Method)
+			if step.ExpectedStatus == 0 {
+				step.ExpectedStatus = http.StatusOK
+			}
+			// 実行中に panic しないように読み込むときに確かめておく
+			if _, err := regexp.Compile(expandPattern(step.ExpectedLocation, nil)); err != nil {
+				return nil, fmt.Errorf("invalid expected_location in step %d of %s: %w", i+1, def.Name, err)
+			}
+			for name, c := range step.Captures {
+				if c.Selector == "" {
+					return nil, fmt.Errorf("capture %s in %s needs selector", name, def.Name)
+				}
+			}
+		}
+	}
+
+	return f.Scenarios, nil
+}
+
+func expandTemplate(s string, vars map[string]string) string {
+	return templateRe.ReplaceAllStringFunc(s, func(m string) string {
+		return vars[templateRe.FindStringSubmatch(m)[1]]
+	})
+}
+
+// expandPattern は正規表現に値を埋め込む。値はそのままの文字列として一致させる
+func expandPattern(s string, vars map[string]string) string {
+	return templateRe.ReplaceAllStringFunc(s, func(m string) string {
+		return "(?:" + regexp.QuoteMeta(vars[templateRe.FindStringSubmatch(m)[1]]) + ")"
+	})
+}
+
+func expandMap(m map[string]string, vars map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	expanded := make(map[string]string, len(m))
+	for k, v := range m {
+		expanded[k] = expandTemplate(v, vars)
+	}
+	return expanded
+}
+
+func (step *stepDefinition) checkFunc(vars map[string]string) func(doc *goquery.Document) error {
+	return func(doc *goquery.Document) error {
+		for _, a := range step.Assertions {
+			sel := doc.Find(a.Selector)
+			if a.Absent {
+				if sel.Length() > 0 {
+					return fmt.Errorf("%s が表示されています", a.Selector)
+				}
+				continue
+			}
+			if sel.Length() < max(a.MinCount, 1) {
+				return fmt.Errorf("%s が表示されていません", a.Selector)
+			}
+			if a.Text != "" && strings.TrimSpace(sel.First().Text()) != expandTemplate(a.Text, vars) {
+				return fmt.Errorf("%s の内容が正しくありません", a.Selector)
+			}
+		}
+
+		for name, c := range step.Captures {
+			sel := doc.Find(c.Selector).First()
+			if sel.Length() == 0 {
+				return fmt.Errorf("%s が取得できません", name)
+			}
+			if c.Attr == "" {
+				vars[name] = strings.TrimSpace(sel.Text())
+			} else if v, ok := sel.Attr(c.Attr); ok {
+				vars[name] = v
+			} else {
+				return fmt.Errorf("%s が取得できません", name)
+			}
+		}
+
+		return nil
+	}
+}
+
+func (step *stepDefinition) play(s *checker.Session, vars map[string]string, image *checker.Asset) error {
+	var a *checker.Action
+	var play func(*checker.Session) error
+
+	path := expandTemplate(step.Path, vars)
+	if step.Upload != "" {
+		upload := checker.NewUploadAction(step.Method, path, step.Upload)
+		upload.Asset = image
+		a, play = upload.Action, upload.Play
+	} else {
+		a = checker.NewAction(step.Method, path)
+		play = a.Play
+	}
+
+	a.Description = step.Description
+	a.PostData = expandMap(step.Form, vars)
+	a.ExpectedStatusCode = step.ExpectedStatus
+	a.ExpectedLocation = expandPattern(step.ExpectedLocation, vars)
+
+	if len(step.Assertions) > 0 || len(step.Captures) > 0 {
+		a.CheckFunc = checkHTML(step.checkFunc(vars))
+	}
+
+	return play(s)
+}
+
+func newDeclarativeScenario(def *scenarioDefinition, users, adminUsers []user, sentences []string, images []*checker.Asset) *mainScenario {
+	return &mainScenario{
+		Name: def.Name,
+		Run: func(r *util.Rand) {
+			me := randomUser(r, users)
+			admin := randomUser(r, adminUsers)
+			vars := map[string]string{
+				"account_name":       me.AccountName,
+				"password":           me.Password,
+				"other_account_name": randomUser(r, users).AccountName,
+				"admin_account_name": admin.AccountName,
+				"admin_password":     admin.Password,
+				"sentence":           randomSentence(r, sentences),
+				"random":             r.LUNStr(16),
+			}
+			image := randomImage(r, images)
+
+			s := newSession(def.Name, r)
+			for _, step := range def.Steps {
+				if err := step.play(s, vars, image); err != nil {
+					return
+				}
+			}
+		},
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestLoadScenarioFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenarios.json")
+	body := `{"scenarios": [{"name": "myComment", "steps": [{"method": "post", "path": "/login"}]}]}`
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defs, err := loadScenarioFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defs[0].Steps[0].Method != "POST" {
+		t.Errorf("expected %q to eq %q", defs[0].Steps[0].Method, "POST")
+	}
+	if defs[0].Steps[0].ExpectedStatus != 200 {
+		t.Errorf("expected %d to eq %d", defs[0].Steps[0].ExpectedStatus, 200)
+	}
+	if *defs[0].Concurrency != 1 {
+		t.Errorf("expected %d to eq %d", *defs[0].Concurrency, 1)
+	}
+
+	body = `{"scenarios": [{"name": "idle", "concurrency": 0, "steps": [{"method": "GET", "path": "/"}]}]}`
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defs, err = loadScenarioFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *defs[0].Concurrency != 0 {
+		t.Errorf("expected %d to eq %d", *defs[0].Concurrency, 0)
+	}
+
+	body = `{"scenarios": [{"name": "broken", "steps": [{"method": "GET", "path": "/", "expected_location": "^/@{{account_name}}($"}]}]}`
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadScenarioFile(path); err == nil {
+		t.Error("expected error for invalid expected_location")
+	}
+
+	body = `{"scenarios": [{"name": "login", "steps": [{"method": "GET", "path": "/"}]}]}`
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadScenarioFile(path); err == nil {
+		t.Error("expected error for duplicate scenario name")
+	}
+}
+
+func TestExpandPattern(t *testing.T) {
+	re := regexp.MustCompile(expandPattern("^/@{{account_name}}$", map[string]string{"account_name": "a.b+"}))
+	if !re.MatchString("/@a.b+") {
+		t.Error("expected the value to match literally")
+	}
+	if re.MatchString("/@axbb") {
+		t.Error("expected the value not to be treated as a pattern")
+	}
+}
+
+func TestStepDefinitionCheckFunc(t *testing.T) {
+	html := `<div class="isu-account-name">mary</div><input name="csrf_token" value="abc">`
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step := &stepDefinition{
+		Assertions: []*assertionDefinition{
+			{Selector: ".isu-account-name", Text: "{{account_name}}"},
+			{Selector: "#notice-message", Absent: true},
+		},
+		Captures: map[string]*captureDefinition{
+			"csrf_token": {Selector: `input[name="csrf_token"]`, Attr: "value"},
+		},
+	}
+	vars := map[string]string{"account_name": "mary"}
+
+	if err := step.checkFunc(vars)(doc); err != nil {
+		t.Fatal(err)
+	}
+	if vars["csrf_token"] != "abc" {
+		t.Errorf("expected %q to eq %q", vars["csrf_token"], "abc")
+	}
+	if got := expandTemplate("/comment?token={{ csrf_token }}", vars); got != "/comment?token=abc" {
+		t.Errorf("expected %q to eq %q", got, "/comment?token=abc")
+	}
+
+	vars["account_name"] = "bob"
+	if err := step.checkFunc(vars)(doc); err == nil {
+		t.Error("expected error for wrong account name")
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/score"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+// 複数のワーカーに負荷をかけさせて結果をまとめる
+// ワーカーはターゲットや userdata などを自分のコマンドライン引数で受け取って待つ
+// コーディネーターは初期化とプリフライトを済ませてから、各ワーカーの POST /run に
+// 並列数、userdata の担当、開始時刻、seed、負荷のかけ方を送る
+// シナリオファイルと静的ファイルは中身のダイジェストを送り、ワーカーのものと違えば断らせる
+// ワーカーは自分自身を -worker-output 付きで起動して集計前の値(workerResult)を返す
+
+// ワーカーが起動するプロセスに渡さない、または実行ごとに付け直すフラグ
+// debug はワーカーでは使わない。失敗はそのままコーディネーターに返るので、コーディネーターの -debug で見る
+var workerOnlyFlags = map[string]bool{
+	"workers":                true,
+	"worker-listen":          true,
+	"worker-token":           true,
+	"worker-part":            true,
+	"worker-output":          true,
+	"start-at":               true,
+	"concurrency":            true,
+	"concurrency-multiplier": true,
+	"weights":                true,
+	"scenarios":              true,
+	"skip-preflight":         true,
+	"seed":                   true,
+	"load-mode":              true,
+	"stage-interval":         true,
+	"step-multiplier":        true,
+	"spike-multiplier":       true,
+	"spike-duration":         true,
+	"debug":                  true,
+	"d":                      true,
+	"timeline-out":           true,
+	"har":                    true,
+	"har-per-scenario":       true,
+	"dump-dir":               true,
+}
+
+// workerRequest はコーディネーターに合わせる値だけを持つ。任意の引数は受け付けない
+type workerRequest struct {
+	Concurrency map[string]int   `json:"concurrency"`
+	Part        int              `json:"part"`
+	Parts       int              `json:"parts"`
+	StartAt     time.Time        `json:"start_at"`
+	Seed        int64            `json:"seed"`
+	Load        loadStageOptions `json:"load"`
+	Settings    workerSettings   `json:"settings"`
+}
+
+func (r *workerRequest) validate() error {
+	if r.Parts <= 0 || r.Part < 0 || r.Part >= r.Parts {
+		return fmt.Errorf("invalid part: %d/%d", r.Part, r.Parts)
+	}
+	// adaptive と open はコーディネーターが断っている
+	switch r.Load.Mode {
+	case LoadModeConstant, LoadModeLinear, LoadModeStep, LoadModeSpike:
+	default:
+		return fmt.Errorf("invalid load-mode: %q", r.Load.Mode)
+	}
+	for name, c := range r.Concurrency {
+		if name == "" || strings.ContainsAny(name, ",=") || c < 0 {
+			return fmt.Errorf("invalid concurrency: %s=%d", name, c)
+		}
+	}
+	return nil
+}
+
+// args は base の後ろに付けて、ワーカーのコマンドライン引数より優先させる
+func (r *workerRequest) args() []string {
+	args := []string{
+		"-concurrency=" + concurrencyProfile(r.Concurrency).String(),
+		"-skip-preflight",
+		"-worker-output",
+		fmt.Sprintf("-worker-part=%d/%d", r.Part, r.Parts),
+		"-start-at=" + r.StartAt.Format(time.RFC3339Nano),
+		"-load-mode=" + r.Load.Mode,
+		"-stage-interval=" + r.Load.StageInterval.String(),
+		fmt.Sprintf("-step-multiplier=%g", r.Load.StepMultiplier),
+		fmt.Sprintf("-spike-multiplier=%g", r.Load.SpikeMultiplier),
+		"-spike-duration=" + r.Load.SpikeDuration.String(),
+	}
+	if r.Seed != 0 {
+		args = append(args, fmt.Sprintf("-seed=%d", r.Seed))
+	}
+	return args
+}
+
+// workerSettings はコーディネーターとワーカーでそろっていないといけないファイルのダイジェスト
+// パスはマシンごとに違ってもよいので中身で比べる
+type workerSettings struct {
+	Scenarios string `json:"scenarios"`
+	Assets    string `json:"assets"`
+}
+
+func newWorkerSettings(definitions []*scenarioDefinition, m assetManifest) workerSettings {
+	return workerSettings{Scenarios: digestJSON(definitions), Assets: digestJSON(m)}
+}
+
+func digestJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return util.GetMD5(b)
+}
+
+// check は違っている設定をコーディネーターが直せるようにフラグの名前で返す
+func (s workerSettings) check(coordinator workerSettings) error {
+	if s.Scenarios != coordinator.Scenarios {
+		return errors.New("scenario-file does not match the coordinator")
+	}
+	if s.Assets != coordinator.Assets {
+		return errors.New("public-dir does not match the coordinator")
+	}
+	return nil
+}
+
+// workerResult はマージできるように集計前の値をそのまま持つ
+type workerResult struct {
+	Score         int64                                  `json:"score"`
+	Success       int64                                  `json:"success"`
+	Fail          int64                                  `json:"fail"`
+	Failures      []*score.Failure                       `json:"failures"`
+	Latencies     map[string]*score.Histogram            `json:"latencies"`
+	PageLoad      map[string]*score.Histogram            `json:"page_load"`
+	HostLatencies map[string]*score.Histogram            `json:"host_latencies"`
+	Scenarios     map[string]score.ScenarioSummary       `json:"scenarios"`
+	Hosts         map[string]score.HostSummary           `json:"hosts"`
+	Cache         score.CacheSummary                     `json:"cache"`
+	Transfer      map[string]score.Transfer              `json:"transfer"`
+	Phases        map[string]map[string]*score.Histogram `json:"phases"`
+	Connections   map[string]score.Connection            `json:"connections"`
+	StartAt       time.Time                              `json:"start_at"`
+	Timeline      []score.TimelineBucket                 `json:"timeline"`
+	Stages        []StageResult                          `json:"stages"`
+}
+
+func collectWorkerResult(stages []StageResult) *workerResult {
+	return &workerResult{
+		Score:         score.GetInstance().GetRawScore(),
+		Success:       score.GetInstance().GetSucesses(),
+		Fail:          score.GetInstance().GetFails(),
+		Failures:      score.GetFailures(),
+		Latencies:     score.GetLatencyInstance().Histograms(),
+		PageLoad:      score.GetPageLoadInstance().Histograms(),
+		HostLatencies: score.GetHostLatencyInstance().Histograms(),
+		Scenarios:     score.GetScenarioSummaries(),
+		Hosts:         score.GetHostSummaries(),
+		Cache:         score.GetCacheInstance().Summary(),
+		Transfer:      score.GetTransferInstance().Routes(),
+		Phases:        score.GetPhaseInstance().Histograms(),
+		Connections:   score.GetConnectionInstance().Routes(),
+		StartAt:       score.GetTimelineInstance().StartedAt(),
+		Timeline:      score.GetTimelineInstance().Buckets(),
+		Stages:        stages,
+	}
+}
+
+// merge はワーカーの結果をこのプロセスの集計に足し合わせる
+func (r *workerResult) merge() {
+	score.GetInstance().Merge(r.Score, r.Success, r.Fail)
+	for _, f := range r.Failures {
+		score.GetFailErrorsInstance().Append(f)
+	}
+	score.GetLatencyInstance().Merge(r.Latencies)
+	score.GetPageLoadInstance().Merge(r.PageLoad)
+	score.GetHostLatencyInstance().Merge(r.HostLatencies)
+	for name, s := range r.Scenarios {
+		score.GetScenarioInstance(name).Merge(s.Score, s.Success, s.Fail)
+	}
+	for name, s := range r.Hosts {
+		score.GetHostInstance(name).Merge(s.Score, s.Success, s.Fail)
+	}
+	score.GetCacheInstance().Merge(r.Cache)
+	score.GetTransferInstance().Merge(r.Transfer)
+	score.GetPhaseInstance().Merge(r.Phases)
+	score.GetConnectionInstance().Merge(r.Connections)
+	score.GetTimelineInstance().MergeAt(r.StartAt, r.Timeline)
+}
+
+// mergeStageResults は同じ番号のステージを足し合わせる
+func mergeStageResults(results [][]StageResult) []StageResult {
+	var merged []StageResult
+	for _, stages := range results {
+		for i, s := range stages {
+			if len(merged) <= i {
+				merged = append(merged, StageResult{
+					Stage:      s.Stage,
+					Start:      s.Start,
+					Duration:   s.Duration,
+					Multiplier: s.Multiplier,
+				})
+			}
+			m := &merged[i]
+			m.Concurrency += s.Concurrency
+			m.Score += s.Score
+			m.Success += s.Success
+			m.Fail += s.Fail
+			m.ErrorRate = errorRate(m.Success, m.Fail)
+		}
+	}
+	return merged
+}
+
+// parseWorkerPart は "i/n" を読む。i は 0 から数える
+func parseWorkerPart(value string) (int, int, error) {
+	i, n, ok := strings.Cut(value, "/")
+	part, err1 := strconv.Atoi(i)
+	parts, err2 := strconv.Atoi(n)
+	if !ok || err1 != nil || err2 != nil || parts <= 0 || part < 0 || part >= parts {
+		return 0, 0, fmt.Errorf("invalid worker-part: %q", value)
+	}
+	return part, parts, nil
+}
+
+// partitionUsers は n 件おきに取り出して、ワーカー同士で同じユーザーを使わないようにする
+func partitionUsers(users []user, part, parts int) []user {
+	partitioned := make([]user, 0, len(users)/parts+1)
+	for i := part; i < len(users); i += parts {
+		partitioned = append(partitioned, users[i])
+	}
+	return partitioned
+}
+
+// workerArgs はワーカーに指定されたフラグのうち、ベンチマークを実行するプロセスにも渡すものを返す
+func workerArgs(flags *flag.FlagSet) []string {
+	args := []string{}
+	flags.Visit(func(f *flag.Flag) {
+		if !workerOnlyFlags[f.Name] {
+			args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value.String()))
+		}
+	})
+	return args
+}
+
+func parseWorkerURLs(value string) []string {
+	var urls []string
+	for _, w := range strings.Split(value, ",") {
+		w = strings.TrimSpace(w)
+		if w == "" {
+			continue
+		}
+		if !strings.Contains(w, "://") {
+			w = "http://" + w
+		}
+		urls = append(urls, strings.TrimSuffix(w, "/"))
+	}
+	return urls
+}
+
+// runCoordinator は並列数とユーザーを分けてワーカーに配り、結果をマージする
+// base の Seed、Load、Settings はすべてのワーカーに送る。Seed はワーカーごとにずらす
+// ワーカーの起動にかかる時間を見込んで、少し先の同じ時刻からいっせいに始めさせる
+func runCoordinator(workers []string, token string, profile concurrencyProfile, base workerRequest, timeout time.Duration) ([]StageResult, error) {
+	profiles := profile.Split(len(workers))
+	startAt := time.Now().Add(WorkerStartDelay)
+
+	reqs := make([]*workerRequest, len(workers))
+	for i := range workers {
+		wr := base
+		wr.Concurrency = profiles[i]
+		wr.Part = i
+		wr.Parts = len(workers)
+		wr.StartAt = startAt
+		if base.Seed != 0 {
+			wr.Seed = base.Seed + int64(i)
+		}
+		reqs[i] = &wr
+	}
+
+	client := &http.Client{Timeout: WorkerStartDelay + timeout + WorkerTimeoutMargin}
+	results, err := runWorkers(client, workers, token, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	stages := make([][]StageResult, 0, len(results))
+	for _, r := range results {
+		r.merge()
+		stages = append(stages, r.Stages)
+	}
+	return mergeStageResults(stages), nil
+}
+
+func runWorkers(client *http.Client, workers []string, token string, reqs []*workerRequest) ([]*workerResult, error) {
+	results := make([]*workerResult, len(workers))
+	errs := make([]error, len(workers))
+
+	var wg sync.WaitGroup
+	for i, w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = requestWorker(client, w, token, reqs[i])
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func requestWorker(client *http.Client, worker, token string, wr *workerRequest) (*workerResult, error) {
+	body, err := json.Marshal(wr)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", worker+"/run", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ワーカー %s に接続できませんでした: %w", worker, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("ワーカー %s でエラーが発生しました: %s", worker, strings.TrimSpace(string(b)))
+	}
+
+	r := &workerResult{}
+	if err := json.NewDecoder(res.Body).Decode(r); err != nil {
+		return nil, fmt.Errorf("ワーカー %s の結果を読めませんでした: %w", worker, err)
+	}
+	return r, nil
+}
+
+// workerServer は一度に1回だけベンチマークを実行する
+// base はワーカーのコマンドライン引数から作った、実行ごとに変わらない引数
+type workerServer struct {
+	mu       sync.Mutex
+	base     []string
+	token    string
+	settings workerSettings
+	run      func(ctx context.Context, args []string) ([]byte, error)
+}
+
+func newWorkerServer(base []string, token string, settings workerSettings) *workerServer {
+	return &workerServer{base: base, token: token, settings: settings, run: runWorkerProcess}
+}
+
+func (ws *workerServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /run", ws.handleRun)
+	return mux
+}
+
+func (ws *workerServer) authorized(r *http.Request) bool {
+	if ws.token == "" {
+		return true
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(ws.token)) == 1
+}
+
+func (ws *workerServer) handleRun(w http.ResponseWriter, r *http.Request) {
+	if !ws.authorized(r) {
+		http.Error(w, "トークンが正しくありません", http.StatusUnauthorized)
+		return
+	}
+
+	if !ws.mu.TryLock() {
+		http.Error(w, "別のベンチマークを実行中です", http.StatusConflict)
+		return
+	}
+	defer ws.mu.Unlock()
+
+	req := &workerRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := ws.settings.check(req.Settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	args := append(slices.Clone(ws.base), req.args()...)
+	out, err := ws.run(r.Context(), args)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// runWorkerProcess は集計をプロセスごとに分けるため自分自身を別プロセスで起動する
+// 結果は標準出力の最後の行
+func runWorkerProcess(ctx context.Context, args []string) ([]byte, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	out := lastLine(stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, out)
+	}
+	return out, nil
+}
+
+func lastLine(b []byte) []byte {
+	lines := bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+	return lines[len(lines)-1]
+}
+
+// isLoopback は addr が自分自身からしか接続できないアドレスなら true
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// serveWorker はトークンが無ければループバックアドレスでしか待ち受けない
+// 受け取った値でベンチマークを実行するので、誰でも接続できるようにはしない
+func serveWorker(addr, token string, base []string, settings workerSettings, errStream io.Writer) int {
+	if token == "" && !isLoopback(addr) {
+		fmt.Fprintln(errStream, "worker-token is required to listen on a non-loopback address")
+		return ExitCodeError
+	}
+
+	fmt.Fprintf(errStream, "worker listening on %s\n", addr)
+	if err := http.ListenAndServe(addr, newWorkerServer(base, token, settings).Handler()); err != nil {
+		fmt.Fprintln(errStream, err)
+		return ExitCodeError
+	}
+	return ExitCodeOK
+}
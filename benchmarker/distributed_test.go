@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/score"
+)
+
+func TestPartitionUsers(t *testing.T) {
+	part, parts, err := parseWorkerPart("1/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if part != 1 || parts != 3 {
+		t.Errorf("expected %d/%d to eq %d/%d", part, parts, 1, 3)
+	}
+	for _, v := range []string{"3/3", "-1/2", "1", "a/b", "0/0"} {
+		if _, _, err := parseWorkerPart(v); err == nil {
+			t.Errorf("expected error for %q", v)
+		}
+	}
+
+	users := []user{}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		users = append(users, user{AccountName: name})
+	}
+
+	seen := map[string]bool{}
+	for i := range 2 {
+		for _, u := range partitionUsers(users, i, 2) {
+			if seen[u.AccountName] {
+				t.Errorf("%s is used by more than one worker", u.AccountName)
+			}
+			seen[u.AccountName] = true
+		}
+	}
+	if len(seen) != len(users) {
+		t.Errorf("expected %d to eq %d", len(seen), len(users))
+	}
+}
+
+// ワーカーのテストではテストのバイナリを benchmarker として起動し直す
+func TestMain(m *testing.M) {
+	if os.Getenv("BENCHMARKER_TEST_WORKER") != "" {
+		cli := &CLI{outStream: os.Stdout, errStream: os.Stderr}
+		os.Exit(cli.Run(append([]string{Name}, os.Args[1:]...)))
+	}
+	os.Exit(m.Run())
+}
+
+func TestWorkerServer(t *testing.T) {
+	h := score.NewHistogram()
+	h.Add(1500)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var gotArgs []string
+	settings := newWorkerSettings(nil, defaultAssetManifest())
+	ws := &workerServer{base: []string{"-target=http://localhost"}, token: "secret", settings: settings, run: func(ctx context.Context, args []string) ([]byte, error) {
+		if slices.Contains(args, "-seed=2") {
+			close(started)
+			<-release
+		} else {
+			gotArgs = args
+		}
+		return json.Marshal(workerResult{
+			Score:     10,
+			Success:   2,
+			Latencies: map[string]*score.Histogram{"GET /": h},
+		})
+	}}
+	ts := httptest.NewServer(ws.Handler())
+	defer ts.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	load := loadStageOptions{Mode: LoadModeStep, StageInterval: time.Second, StepMultiplier: 1, SpikeMultiplier: 5, SpikeDuration: time.Second}
+	wr := &workerRequest{Concurrency: map[string]int{"loadIndex": 2}, Part: 0, Parts: 1, StartAt: time.Now(), Load: load, Settings: settings}
+
+	if _, err := requestWorker(client, ts.URL, "wrong", wr); err == nil {
+		t.Error("expected a wrong token to be rejected")
+	}
+	if _, err := requestWorker(client, ts.URL, "secret", &workerRequest{Parts: 1, Part: 1, Load: load, Settings: settings}); err == nil {
+		t.Error("expected an invalid part to be rejected")
+	}
+	if _, err := requestWorker(client, ts.URL, "secret", &workerRequest{Parts: 1, Load: loadStageOptions{Mode: LoadModeOpen}, Settings: settings}); err == nil {
+		t.Error("expected open mode to be rejected")
+	}
+
+	// シナリオファイルや静的ファイルがコーディネーターと違えば実行しない
+	mismatch := *wr
+	mismatch.Settings = newWorkerSettings(nil, assetManifest{"/css/style.css": "x"})
+	if _, err := requestWorker(client, ts.URL, "secret", &mismatch); err == nil || !strings.Contains(err.Error(), "public-dir") {
+		t.Errorf("expected a different manifest to be rejected, got %v", err)
+	}
+	one := 1
+	mismatch.Settings = newWorkerSettings([]*scenarioDefinition{{Name: "extra", Concurrency: &one}}, defaultAssetManifest())
+	if _, err := requestWorker(client, ts.URL, "secret", &mismatch); err == nil || !strings.Contains(err.Error(), "scenario-file") {
+		t.Errorf("expected different scenarios to be rejected, got %v", err)
+	}
+
+	results, err := runWorkers(client, []string{ts.URL}, "secret", []*workerRequest{wr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := results[0]
+	if r.Score != 10 || r.Success != 2 {
+		t.Errorf("expected %d/%d to eq %d/%d", r.Score, r.Success, 10, 2)
+	}
+	if got := r.Latencies["GET /"]; got == nil || got.Total != 1 || got.Max != 1500 {
+		t.Errorf("expected histogram to survive the round trip: %+v", got)
+	}
+	if gotArgs[0] != "-target=http://localhost" || !slices.Contains(gotArgs, "-concurrency=loadIndex=2") || !slices.Contains(gotArgs, "-load-mode=step") {
+		t.Errorf("unexpected args: %v", gotArgs)
+	}
+
+	// 実行中は次のリクエストを受け付けない
+	done := make(chan error)
+	go func() {
+		_, err := requestWorker(client, ts.URL, "secret", &workerRequest{Parts: 1, Seed: 2, Load: load, Settings: settings})
+		done <- err
+	}()
+	<-started
+
+	if _, err := requestWorker(client, ts.URL, "secret", wr); err == nil || !strings.Contains(err.Error(), "実行中") {
+		t.Errorf("expected the second run to be rejected, got %v", err)
+	}
+
+	close(release)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker did not finish")
+	}
+}
+
+func TestRequestWorkerTimeout(t *testing.T) {
+	hang := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer ts.Close()
+	defer close(hang)
+
+	// 返ってこないワーカーがいても待ち続けない
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	if _, err := requestWorker(client, ts.URL, "", &workerRequest{Parts: 1}); err == nil {
+		t.Error("expected a hung worker to time out")
+	}
+}
+
+func TestServeWorkerRequiresToken(t *testing.T) {
+	for addr, expected := range map[string]bool{
+		"127.0.0.1:7001": true,
+		"localhost:7001": true,
+		"[::1]:7001":     true,
+		":7001":          false,
+		"0.0.0.0:7001":   false,
+		"10.0.0.1:7001":  false,
+	} {
+		if got := isLoopback(addr); got != expected {
+			t.Errorf("%s: expected %v to eq %v", addr, got, expected)
+		}
+	}
+
+	errStream := new(bytes.Buffer)
+	if status := serveWorker(":0", "", nil, workerSettings{}, errStream); status != ExitCodeError {
+		t.Errorf("expected %d to eq %d", status, ExitCodeError)
+	}
+}
+
+// 実際にワーカーのプロセスを2つ起動して、結果がまとまることを確かめる
+func TestRunCoordinator(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts worker processes")
+	}
+
+	var requests atomic.Int64
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte("<html><body></body></html>"))
+	}))
+	defer target.Close()
+
+	userdata := t.TempDir()
+	names := []string{}
+	for i := range 20 {
+		names = append(names, fmt.Sprintf("user%d", i))
+	}
+	os.WriteFile(filepath.Join(userdata, "names.txt"), []byte(strings.Join(names, "\n")), 0644)
+	os.WriteFile(filepath.Join(userdata, "kaomoji.txt"), []byte("(^_^)\n"), 0644)
+	os.MkdirAll(filepath.Join(userdata, "img"), 0755)
+	var img bytes.Buffer
+	png.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8)))
+	os.WriteFile(filepath.Join(userdata, "img", "00001.png"), img.Bytes(), 0644)
+
+	t.Setenv("BENCHMARKER_TEST_WORKER", "1")
+	base := []string{"-target=" + target.URL, "-userdata=" + userdata, "-benchmark-timeout=1s", "-wait-after-timeout=0s"}
+	var workers []string
+	for range 2 {
+		ts := httptest.NewServer(newWorkerServer(base, "", newWorkerSettings(nil, defaultAssetManifest())).Handler())
+		defer ts.Close()
+		workers = append(workers, ts.URL)
+	}
+
+	before := score.GetInstance().GetSucesses() + score.GetInstance().GetFails()
+	profile := defaultConcurrencyProfile()
+	// ワーカーは -load-mode を指定せずに起動しているので、step で動けばコーディネーターから届いている
+	load := loadStageOptions{Mode: LoadModeStep, StageInterval: 500 * time.Millisecond, StepMultiplier: 1}
+	stages, err := runCoordinator(workers, "", profile, workerRequest{Seed: 1, Load: load, Settings: newWorkerSettings(nil, defaultAssetManifest())}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requests.Load() == 0 {
+		t.Error("expected the workers to send requests")
+	}
+	if after := score.GetInstance().GetSucesses() + score.GetInstance().GetFails(); after <= before {
+		t.Errorf("expected %d to be greater than %d", after, before)
+	}
+	// 両方のワーカーが分けた並列数で動いていれば合計は元の並列数になる
+	total := 0
+	for _, c := range profile {
+		total += c
+	}
+	if len(stages) != 2 || stages[0].Concurrency != total || stages[1].Concurrency != 2*total {
+		t.Errorf("expected %v to have concurrency %d and %d", stages, total, 2*total)
+	}
+}
+
+func TestWorkerArgs(t *testing.T) {
+	flags := flag.NewFlagSet(Name, flag.ContinueOnError)
+	for _, name := range []string{"target", "load-mode", "scenario-file"} {
+		flags.String(name, "", "")
+	}
+	flags.Bool("d", false, "")
+	flags.Parse([]string{"-target=http://localhost", "-load-mode=spike", "-scenario-file=s.json", "-d"})
+
+	// 負荷のかけ方はコーディネーターから届いたものを使い、debug はワーカーでは使わない
+	expected := []string{"-scenario-file=s.json", "-target=http://localhost"}
+	if got := workerArgs(flags); !slices.Equal(got, expected) {
+		t.Errorf("expected %v to eq %v", got, expected)
+	}
+}
+
+func TestMergeStageResults(t *testing.T) {
+	merged := mergeStageResults([][]StageResult{
+		{{Stage: 1, Concurrency: 3, Score: 10, Success: 9, Fail: 1}},
+		{{Stage: 1, Concurrency: 2, Score: 5, Success: 9, Fail: 1}},
+	})
+
+	if len(merged) != 1 {
+		t.Fatalf("expected %d to eq %d", len(merged), 1)
+	}
+	m := merged[0]
+	if m.Concurrency != 5 || m.Score != 15 || m.Success != 18 || m.Fail != 2 {
+		t.Errorf("unexpected merge result: %+v", m)
+	}
+	if m.ErrorRate != 0.1 {
+		t.Errorf("expected %v to eq %v", m.ErrorRate, 0.1)
+	}
+}
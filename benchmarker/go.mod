@@ -4,7 +4,10 @@ go 1.26.0
 
 require (
 	github.com/PuerkitoBio/goquery v1.12.0
+	github.com/andybalholm/brotli v1.2.6
+	github.com/klauspost/compress v1.20.1
 	github.com/marcw/cachecontrol v0.0.0-20140722115028-30341fe9a7d5
+	golang.org/x/image v0.46.0
 )
 
 require (
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+// newSession はシナリオ名と乱数を付けたセッションを作る
+func newSession(scenario string, r *util.Rand) *checker.Session {
+	s := checker.NewSessionWithRand(r)
+	s.Scenario = scenario
+	return s
+}
+
+// newRand は -seed が指定されていれば name と index ごとに固定の乱数を作る
+// 指定されていなければ nil を返してグローバルな乱数を使う
+func newRand(seed int64, name string, index int) *util.Rand {
+	if seed == 0 {
+		return nil
+	}
+	return util.NewRand(uint64(seed), name, index)
+}
+
+// ベンチマーク本体で繰り返し実行するシナリオ
+type mainScenario struct {
+	Name string
+	Run  func(r *util.Rand)
+}
+
+func newMainScenarios(users, adminUsers []user, sentences []string, images []*checker.Asset) []*mainScenario {
+	return []*mainScenario{
+		{
+			Name: "indexMoreAndMore",
+			Run: func(r *util.Rand) {
+				indexMoreAndMoreScenario(newSession("indexMoreAndMore", r))
+			},
+		},
+		{
+			Name: "loadIndex",
+			Run: func(r *util.Rand) {
+				loadIndexScenario(newSession("loadIndex", r))
+			},
+		},
+		{
+			Name: "userAndPostPage",
+			Run: func(r *util.Rand) {
+				userAndPostPageScenario(newSession("userAndPostPage", r), randomUser(r, users).AccountName)
+			},
+		},
+		{
+			Name: "comment",
+			Run: func(r *util.Rand) {
+				commentScenario(newSession("comment", r), randomUser(r, users), randomUser(r, users).AccountName, randomSentence(r, sentences))
+			},
+		},
+		{
+			Name: "postImage",
+			Run: func(r *util.Rand) {
+				postImageScenario(newSession("postImage", r), randomUser(r, users), randomImage(r, images), randomSentence(r, sentences))
+				cannotPostWrongCSRFTokenScenario(newSession("postImage", r), randomUser(r, users), randomImage(r, images))
+			},
+		},
+		{
+			Name: "login",
+			Run: func(r *util.Rand) {
+				loginScenario(newSession("login", r), randomUser(r, users))
+				cannotLoginNonexistentUserScenario(newSession("login", r))
+				cannotLoginWrongPasswordScenario(newSession("login", r), randomUser(r, users))
+			},
+		},
+		{
+			Name: "ban",
+			Run: func(r *util.Rand) {
+				banScenario(newSession("ban", r), newSession("ban", r), randomUser(r, users), randomUser(r, adminUsers), randomImage(r, images), randomSentence(r, sentences))
+				cannotAccessAdminScenario(newSession("ban", r), randomUser(r, users))
+			},
+		},
+	}
+}
+
+// scenarioPool は1つのシナリオを指定された並列数で回し続ける
+// 並列数を減らしたときは実行中のシナリオが終わったworkerから抜ける
+// started は乱数の index に使う。増やし直したときに同じ乱数列を繰り返さないように減らさない
+type scenarioPool struct {
+	sync.Mutex
+	sc      *mainScenario
+	seed    int64
+	size    int
+	active  int
+	started int
+	stopped bool
+}
+
+func newScenarioPools(scenarios []*mainScenario, seed int64) []*scenarioPool {
+	pools := make([]*scenarioPool, 0, len(scenarios))
+	for _, sc := range scenarios {
+		pools = append(pools, &scenarioPool{sc: sc, seed: seed})
+	}
+	return pools
+}
+
+func (p *scenarioPool) Resize(n int) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.stopped {
+		return
+	}
+
+	p.size = n
+	for p.active < p.size {
+		p.active++
+		p.started++
+		go p.work(newRand(p.seed, p.sc.Name, p.started))
+	}
+}
+
+// Stop 後も実行中のシナリオはそのまま最後まで走らせる
+func (p *scenarioPool) Stop() {
+	p.Lock()
+	p.stopped = true
+	p.Unlock()
+}
+
+func (p *scenarioPool) work(r *util.Rand) {
+	for {
+		p.Lock()
+		if p.stopped || p.active > p.size {
+			p.active--
+			p.Unlock()
+			return
+		}
+		p.Unlock()
+
+		p.sc.Run(r)
+	}
+}
+
+func resizePools(pools []*scenarioPool, profile concurrencyProfile) int {
+	total := 0
+	for _, p := range pools {
+		n := profile[p.sc.Name]
+		p.Resize(n)
+		total += n
+	}
+	return total
+}
+
+func stopPools(pools []*scenarioPool) {
+	for _, p := range pools {
+		p.Stop()
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+func TestScenarioPoolResizeUsesNewRand(t *testing.T) {
+	values := make(chan int)
+	release := make(chan struct{})
+	sc := &mainScenario{
+		Name: "test",
+		Run: func(r *util.Rand) {
+			values <- r.Number(1 << 30)
+			<-release
+		},
+	}
+	p := newScenarioPools([]*mainScenario{sc}, 1)[0]
+
+	p.Resize(1)
+	first := <-values
+
+	// 減らしてから増やし直しても同じ乱数列を使わない
+	p.Resize(0)
+	release <- struct{}{}
+	for {
+		p.Lock()
+		active := p.active
+		p.Unlock()
+		if active == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	p.Resize(1)
+	second := <-values
+	p.Stop()
+	release <- struct{}{}
+
+	if first == second {
+		t.Errorf("expected %d not to eq %d", second, first)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+const LoadModeOpen = "open"
+
+type openLoopOptions struct {
+	Rate        float64
+	MaxInFlight int
+}
+
+// OpenLoopResult の Late は予定時刻から1間隔以上遅れて開始したもの、
+// Dropped は同時実行数の上限に達していて開始できなかったもの
+type OpenLoopResult struct {
+	Rate      float64 `json:"rate"`
+	Scheduled int64   `json:"scheduled"`
+	Started   int64   `json:"started"`
+	Dropped   int64   `json:"dropped"`
+	Late      int64   `json:"late"`
+	MaxLag    float64 `json:"max_lag"`
+}
+
+// runOpenLoop は前のシナリオの完了を待たずに一定のレートでシナリオを開始する
+// どのシナリオを開始するかは並列数の比で選ぶ
+// -seed が指定されていれば選ぶシナリオと各シナリオの乱数は開始順で固定になる
+func runOpenLoop(scenarios []*mainScenario, profile concurrencyProfile, total time.Duration, opts openLoopOptions, seed int64) *OpenLoopResult {
+	result := &OpenLoopResult{Rate: opts.Rate}
+
+	weights := make([]int, len(scenarios))
+	sum := 0
+	for i, sc := range scenarios {
+		weights[i] = profile[sc.Name]
+		sum += weights[i]
+	}
+	if sum == 0 {
+		return result
+	}
+
+	interval := time.Duration(float64(time.Second) / opts.Rate)
+	sem := make(chan struct{}, opts.MaxInFlight)
+	picker := newRand(seed, "openloop", 0)
+
+	start := time.Now()
+	for i := 0; ; i++ {
+		scheduled := start.Add(time.Duration(float64(i) / opts.Rate * float64(time.Second)))
+		if scheduled.Sub(start) >= total {
+			break
+		}
+		if d := time.Until(scheduled); d > 0 {
+			time.Sleep(d)
+		}
+
+		result.Scheduled++
+		lag := time.Since(scheduled)
+		if lag >= interval {
+			result.Late++
+		}
+		result.MaxLag = max(result.MaxLag, float64(lag.Microseconds())/1000)
+
+		sc := pickScenario(picker, scenarios, weights, sum)
+		r := newRand(seed, sc.Name, i)
+
+		select {
+		case sem <- struct{}{}:
+			result.Started++
+			go func() {
+				sc.Run(r)
+				<-sem
+			}()
+		default:
+			result.Dropped++
+		}
+	}
+
+	return result
+}
+
+func pickScenario(r *util.Rand, scenarios []*mainScenario, weights []int, sum int) *mainScenario {
+	n := r.Number(sum)
+	for i, w := range weights {
+		if n < w {
+			return scenarios[i]
+		}
+		n -= w
+	}
+	return scenarios[len(scenarios)-1]
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+const (
+	ReplayFormatAuto  = "auto"
+	ReplayFormatNginx = "nginx"
+	ReplayFormatLTSV  = "ltsv"
+	ReplayFormatHAR   = "har"
+
+	replayScenarioName = "replay"
+)
+
+// replayRequest はアクセスログやHARの1リクエスト分
+// Client が同じものは同じセッション(cookie)で順番に送る
+type replayRequest struct {
+	Offset   time.Duration
+	Client   string
+	Method   string
+	Path     string
+	Status   int
+	PostData map[string]string
+}
+
+// nginxのデフォルトのcombined形式
+// $remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"
+var combinedLogRe = regexp.MustCompile(`^(\S+) \S+ \S+ \[([^\]]+)\] "(\S+) (\S+)[^"]*" (\d{3}) \S+ "[^"]*" "([^"]*)"`)
+
+const timeLocalLayout = "02/Jan/2006:15:04:05 -0700"
+
+func loadReplayRequests(file, format string) ([]*replayRequest, error) {
+	if format == ReplayFormatAuto {
+		switch {
+		case strings.HasSuffix(file, ".har"):
+			format = ReplayFormatHAR
+		case strings.HasSuffix(file, ".ltsv"):
+			format = ReplayFormatLTSV
+		default:
+			format = ReplayFormatNginx
+		}
+	}
+
+	var reqs []*replayRequest
+	var times []time.Time
+	var err error
+
+	switch format {
+	case ReplayFormatNginx, ReplayFormatLTSV:
+		reqs, times, err = loadAccessLog(file, format)
+	case ReplayFormatHAR:
+		reqs, times, err = loadReplayHAR(file)
+	default:
+		return nil, fmt.Errorf("unknown replay format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(reqs) == 0 {
+		return nil, errors.New("no requests to replay")
+	}
+
+	first := slices.MinFunc(times, func(a, b time.Time) int { return a.Compare(b) })
+	for i, r := range reqs {
+		r.Offset = times[i].Sub(first)
+	}
+	slices.SortStableFunc(reqs, func(a, b *replayRequest) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+
+	return reqs, nil
+}
+
+func loadAccessLog(file, format string) ([]*replayRequest, []time.Time, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var reqs []*replayRequest
+	var times []time.Time
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var r *replayRequest
+		var t time.Time
+		if format == ReplayFormatLTSV {
+			r, t, err = parseLTSVLine(line)
+		} else {
+			r, t, err = parseCombinedLine(line)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		reqs = append(reqs, r)
+		times = append(times, t)
+	}
+
+	return reqs, times, scanner.Err()
+}
+
+func parseCombinedLine(line string) (*replayRequest, time.Time, error) {
+	m := combinedLogRe.FindStringSubmatch(line)
+	if m == nil {
+		return nil, time.Time{}, fmt.Errorf("invalid access log: %q", line)
+	}
+
+	t, err := time.Parse(timeLocalLayout, m[2])
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	status, _ := strconv.Atoi(m[5])
+
+	return &replayRequest{
+		Client: m[1] + " " + m[6],
+		Method: m[3],
+		Path:   m[4],
+		Status: status,
+	}, t, nil
+}
+
+// alpなどでよく使われるLTSV形式。time, method, uri (またはreq), status, host, ua を使う
+func parseLTSVLine(line string) (*replayRequest, time.Time, error) {
+	fields := map[string]string{}
+	for _, kv := range strings.Split(line, "\t") {
+		if k, v, ok := strings.Cut(kv, ":"); ok {
+			fields[k] = v
+		}
+	}
+
+	method, uri := fields["method"], fields["uri"]
+	if req, ok := fields["req"]; ok && (method == "" || uri == "") {
+		parts := strings.Fields(req)
+		if len(parts) >= 2 {
+			method, uri = parts[0], parts[1]
+		}
+	}
+	if method == "" || uri == "" {
+		return nil, time.Time{}, fmt.Errorf("invalid access log: %q", line)
+	}
+
+	var t time.Time
+	var err error
+	for _, layout := range []string{timeLocalLayout, time.RFC3339} {
+		if t, err = time.Parse(layout, fields["time"]); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid time in access log: %q", line)
+	}
+	status, _ := strconv.Atoi(fields["status"])
+
+	return &replayRequest{
+		Client: fields["host"] + " " + fields["ua"],
+		Method: method,
+		Path:   uri,
+		Status: status,
+	}, t, nil
+}
+
+// HARは -har で記録したものを想定している。_scenario と _session があればそれでセッションを分ける
+func loadReplayHAR(file string) ([]*replayRequest, []time.Time, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var har harLogFile
+	if err := json.Unmarshal(b, &har); err != nil {
+		return nil, nil, fmt.Errorf("invalid HAR file %s: %w", file, err)
+	}
+
+	var reqs []*replayRequest
+	var times []time.Time
+	for _, e := range har.Log.Entries {
+		u, err := url.Parse(e.Request.URL)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		r := &replayRequest{
+			Client: fmt.Sprintf("%s %s %d", e.Scenario, e.Pageref, e.Session),
+			Method: e.Request.Method,
+			Path:   u.RequestURI(),
+			Status: e.Response.Status,
+		}
+
+		if pd := e.Request.PostData; pd != nil {
+			if len(pd.Params) > 0 {
+				r.PostData = map[string]string{}
+				for _, p := range pd.Params {
+					r.PostData[p.Name] = p.Value
+				}
+			} else if strings.HasPrefix(pd.MimeType, "application/x-www-form-urlencoded") {
+				values, _ := url.ParseQuery(pd.Text)
+				r.PostData = map[string]string{}
+				for k := range values {
+					r.PostData[k] = values.Get(k)
+				}
+			}
+		}
+
+		reqs = append(reqs, r)
+		times = append(times, e.StartedDateTime)
+	}
+
+	return reqs, times, nil
+}
+
+type harLogFile struct {
+	Log struct {
+		Entries []struct {
+			StartedDateTime time.Time `json:"startedDateTime"`
+			Pageref         string    `json:"pageref"`
+			Scenario        string    `json:"_scenario"`
+			Session         int64     `json:"_session"`
+			Request         struct {
+				Method   string `json:"method"`
+				URL      string `json:"url"`
+				PostData *struct {
+					MimeType string `json:"mimeType"`
+					Text     string `json:"text"`
+					Params   []struct {
+						Name  string `json:"name"`
+						Value string `json:"value"`
+					} `json:"params"`
+				} `json:"postData"`
+			} `json:"request"`
+			Response struct {
+				Status int `json:"status"`
+			} `json:"response"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+type replayOptions struct {
+	Speed float64
+}
+
+// ReplayResult の Late は予定時刻から1秒以上遅れて送ったもの
+type ReplayResult struct {
+	Requests int64   `json:"requests"`
+	Replayed int64   `json:"replayed"`
+	Skipped  int64   `json:"skipped"`
+	Late     int64   `json:"late"`
+	Clients  int     `json:"clients"`
+	Speed    float64 `json:"speed"`
+}
+
+// replayClient は記録された1クライアント分のリクエストを1つのセッションで順番に送る
+// ログにはPOSTのボディが残らないので、ログイン情報やCSRFトークン、post_idは
+// userdataとそれまでに受け取ったHTMLから補う
+type replayClient struct {
+	s         *checker.Session
+	r         *util.Rand
+	me        user
+	csrfToken string
+	postID    string
+
+	sentences []string
+	images    []*checker.Asset
+}
+
+func isReplayPage(p string) bool {
+	ext := path.Ext(strings.SplitN(p, "?", 2)[0])
+	return ext == "" || ext == ".html"
+}
+
+func (c *replayClient) capture(doc *goquery.Document) error {
+	if token, ok := doc.Find(`input[name="csrf_token"]`).First().Attr("value"); ok {
+		c.csrfToken = token
+	}
+	if postID, ok := doc.Find(`input[name="post_id"]`).First().Attr("value"); ok {
+		c.postID = postID
+	}
+	return nil
+}
+
+// play はリクエストを送れなかった(送らなかった)ときに false を返す
+func (c *replayClient) play(req *replayRequest) bool {
+	expected := req.Status
+	if expected == http.StatusNotModified {
+		expected = http.StatusOK
+	}
+
+	form := map[string]string{}
+	for k, v := range req.PostData {
+		form[k] = v
+	}
+
+	if req.Method == "POST" {
+		if _, ok := form["csrf_token"]; ok || req.PostData == nil {
+			form["csrf_token"] = c.csrfToken
+		}
+
+		switch strings.SplitN(req.Path, "?", 2)[0] {
+		case "/login":
+			if req.PostData == nil {
+				form = map[string]string{"account_name": c.me.AccountName, "password": c.me.Password}
+			}
+		case "/register":
+			if req.PostData == nil {
+				name := c.r.LUNStr(25)
+				c.me = user{AccountName: name, Password: name}
+				form = map[string]string{"account_name": name, "password": name}
+			}
+		case "/comment":
+			if req.PostData == nil {
+				if c.postID == "" {
+					return false
+				}
+				form["post_id"] = c.postID
+				form["comment"] = randomSentence(c.r, c.sentences)
+			}
+		case "/":
+			upload := checker.NewUploadAction("POST", req.Path, "file")
+			upload.Description = "記録された画像投稿を再生できること"
+			upload.ExpectedStatusCode = expected
+			upload.Asset = randomImage(c.r, c.images)
+			form["body"] = randomSentence(c.r, c.sentences)
+			upload.PostData = form
+			upload.Play(c.s)
+			return true
+		case "/admin/banned":
+			// 記録からはどのユーザーをbanしたかわからないし、ユーザーを減らしたくないので送らない
+			return false
+		}
+	}
+
+	a := checker.NewAction(req.Method, req.Path)
+	a.Description = "記録されたリクエストを再生できること"
+	a.ExpectedStatusCode = expected
+	if req.Method == "POST" {
+		a.PostData = form
+	}
+	if isReplayPage(req.Path) && expected == http.StatusOK {
+		a.CheckFunc = checkHTML(c.capture)
+	}
+	a.Play(c.s)
+
+	return true
+}
+
+// runReplay は記録された時刻の間隔を speed 倍に縮めてリクエストを送る
+// timeout を過ぎる予定のリクエストは送らない
+func runReplay(reqs []*replayRequest, timeout time.Duration, opts replayOptions, seed int64, users []user, sentences []string, images []*checker.Asset) *ReplayResult {
+	result := &ReplayResult{Speed: opts.Speed}
+
+	clients := map[string][]*replayRequest{}
+	var order []string
+	for _, r := range reqs {
+		if _, ok := clients[r.Client]; !ok {
+			order = append(order, r.Client)
+		}
+		clients[r.Client] = append(clients[r.Client], r)
+	}
+	result.Clients = len(clients)
+
+	var replayed, skipped, late atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	for i, key := range order {
+		r := newRand(seed, replayScenarioName, i)
+		s := newSession(replayScenarioName, r)
+		// リダイレクト先も記録に含まれているので自分では辿らない
+		s.Client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+
+		c := &replayClient{
+			s:         s,
+			r:         r,
+			me:        randomUser(r, users),
+			sentences: sentences,
+			images:    images,
+		}
+
+		wg.Add(1)
+		go func(reqs []*replayRequest) {
+			defer wg.Done()
+			for _, req := range reqs {
+				at := time.Duration(float64(req.Offset) / opts.Speed)
+				if at >= timeout {
+					skipped.Add(1)
+					continue
+				}
+				if d := at - time.Since(start); d > 0 {
+					time.Sleep(d)
+				} else if -d >= time.Second {
+					late.Add(1)
+				}
+
+				if c.play(req) {
+					replayed.Add(1)
+				} else {
+					skipped.Add(1)
+				}
+			}
+		}(clients[key])
+	}
+
+	wg.Wait()
+
+	result.Requests = int64(len(reqs))
+	result.Replayed = replayed.Load()
+	result.Skipped = skipped.Load()
+	result.Late = late.Load()
+	return result
+}
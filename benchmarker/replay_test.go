@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadReplayRequests_nginx(t *testing.T) {
+	log := `192.168.0.1 - - [18/Oct/2026:10:00:00 +0900] "GET / HTTP/1.1" 200 1234 "-" "Mozilla/5.0"
+192.168.0.1 - - [18/Oct/2026:10:00:02 +0900] "POST /login HTTP/1.1" 302 0 "-" "Mozilla/5.0"
+192.168.0.2 - - [18/Oct/2026:10:00:01 +0900] "GET /image/1.jpg HTTP/1.1" 304 0 "-" "curl/8.0"
+`
+	path := filepath.Join(t.TempDir(), "access.log")
+	if err := os.WriteFile(path, []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs, err := loadReplayRequests(path, ReplayFormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 3 {
+		t.Fatalf("expected %d to eq %d", len(reqs), 3)
+	}
+
+	if reqs[1].Path != "/image/1.jpg" || reqs[1].Offset != time.Second {
+		t.Errorf("unexpected request: %+v", reqs[1])
+	}
+	if reqs[2].Method != "POST" || reqs[2].Status != 302 || reqs[2].Client != reqs[0].Client {
+		t.Errorf("unexpected request: %+v", reqs[2])
+	}
+}
+
+func TestParseLTSVLine(t *testing.T) {
+	r, _, err := parseLTSVLine("time:18/Oct/2026:10:00:00 +0900\thost:10.0.0.1\treq:GET /posts?max_created_at=x HTTP/1.1\tstatus:200\tua:bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Method != "GET" || r.Path != "/posts?max_created_at=x" || r.Status != 200 {
+		t.Errorf("unexpected request: %+v", r)
+	}
+}
+
+func TestLoadReplayRequests_har(t *testing.T) {
+	har := `{"log": {"entries": [
+		{"startedDateTime": "2026-10-18T10:00:00.5Z", "_scenario": "login",
+		 "request": {"method": "POST", "url": "http://localhost/login",
+		  "postData": {"mimeType": "application/x-www-form-urlencoded", "text": "account_name=mary&password=marymary"}},
+		 "response": {"status": 302}},
+		{"startedDateTime": "2026-10-18T10:00:00Z", "_scenario": "login",
+		 "request": {"method": "GET", "url": "http://localhost/login"}, "response": {"status": 200}}
+	]}}`
+	path := filepath.Join(t.TempDir(), "trace.har")
+	if err := os.WriteFile(path, []byte(har), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs, err := loadReplayRequests(path, ReplayFormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reqs[1].Offset != 500*time.Millisecond {
+		t.Errorf("expected %v to eq %v", reqs[1].Offset, 500*time.Millisecond)
+	}
+	if reqs[1].PostData["account_name"] != "mary" {
+		t.Errorf("expected %q to eq %q", reqs[1].PostData["account_name"], "mary")
+	}
+}
@@ -6,11 +6,12 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/catatsuy/private-isu/benchmarker/checker"
-	"github.com/catatsuy/private-isu/benchmarker/util"
+	"github.com/catatsuy/private-isu/benchmarker/score"
 	"slices"
 )
 
@@ -24,14 +25,34 @@ func checkHTML(f func(*goquery.Document) error) func(io.Reader) error {
 	}
 }
 
+// 画像を同時に読み込む数。ブラウザと同じようにセッションごとに数える
+var imageParallelism = 1
+
 // 1ページに表示される画像にリクエストする
-// TODO: 画像には並列リクエストするべきでは？
-func loadImages(s *checker.Session, imageURLs []string) {
-	for _, url := range imageURLs {
-		imgReq := checker.NewAssetAction(url, &checker.Asset{})
-		imgReq.Description = "投稿画像を読み込めること"
-		imgReq.Play(s)
+// 全部読み込み終わるまでの時間を page ("/" や "/posts/:id") の読み込み時間として記録する
+func loadImages(s *checker.Session, page string, imageURLs []string) {
+	if len(imageURLs) == 0 {
+		return
 	}
+
+	start := time.Now()
+	sem := make(chan struct{}, imageParallelism)
+	var wg sync.WaitGroup
+
+	for _, url := range imageURLs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			imgReq := checker.NewImageAction(url, &checker.Asset{})
+			imgReq.Description = "投稿画像を読み込めること"
+			imgReq.Play(s)
+			<-sem
+		}()
+	}
+
+	wg.Wait()
+	score.GetPageLoadInstance().Record(page, time.Since(start))
 }
 
 func extractImages(doc *goquery.Document) []string {
@@ -46,6 +67,22 @@ func extractImages(doc *goquery.Document) []string {
 	return imageURLs
 }
 
+// containsImage は画像のURLをパスで比べる
+// ホストごとに絶対URLで画像を出していても、別のホストで見た画像と同じものか確認できるように
+func containsImage(imageURLs []string, imageURL string) bool {
+	return slices.ContainsFunc(imageURLs, func(u string) bool {
+		return urlPath(u) == urlPath(imageURL)
+	})
+}
+
+func urlPath(ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.Path
+}
+
 func extractPostLinks(doc *goquery.Document) []string {
 	postLinks := make([]string, 0, PostsPerPage)
 
@@ -58,33 +95,16 @@ func extractPostLinks(doc *goquery.Document) []string {
 	return postLinks
 }
 
-// 普通のページに表示されるべき静的ファイルに一通りアクセス
-func loadAssets(s *checker.Session) {
-	a := checker.NewAssetAction("/favicon.ico", &checker.Asset{MD5: "ad4b0f606e0f8465bc4c4c170b37e1a3"})
-	a.Description = "faviconが読み込めること"
-	a.Play(s)
-
-	a = checker.NewAssetAction("js/timeago.min.js", &checker.Asset{MD5: "f2d4c53400d0a46de704f5a97d6d04fb"})
-	a.Description = "timeago.min.jsが読み込めること"
-	a.Play(s)
-
-	a = checker.NewAssetAction("/js/main.js", &checker.Asset{MD5: "9c309fed7e360c57a705978dab2c68ad"})
-	a.Description = "main.jsが読み込めること"
-	a.Play(s)
-
-	a = checker.NewAssetAction("/css/style.css", &checker.Asset{MD5: "e4c3606a18d11863189405eb5c6ca551"})
-	a.Description = "style.cssが読み込めること"
-	a.Play(s)
-}
-
 // インデックスにリクエストして「もっと見る」を最大10ページ辿る
 // WaitAfterTimeout秒たったら問答無用で打ち切る
 func indexMoreAndMoreScenario(s *checker.Session) {
 	var imageURLs []string
+	var assetURLs []string
 	start := time.Now()
 
 	imagePerPageChecker := checkHTML(func(doc *goquery.Document) error {
 		imageURLs = extractImages(doc)
+		assetURLs = extractAssets(doc)
 		if len(imageURLs) < PostsPerPage {
 			return errors.New("1ページに表示される画像の数が足りません")
 		}
@@ -100,11 +120,11 @@ func indexMoreAndMoreScenario(s *checker.Session) {
 		return
 	}
 
-	loadAssets(s)
-	loadImages(s, imageURLs)
+	loadAssets(s, assetURLs)
+	loadImages(s, "/", imageURLs)
 
-	offset := util.RandomNumber(10) // 10は適当。URLをバラけさせるため
-	for i := range 10 {             // 10ページ辿る
+	offset := s.Rand.Number(10) // 10は適当。URLをバラけさせるため
+	for i := range 10 {         // 10ページ辿る
 		maxCreatedAt := time.Date(2016, time.January, 2, 11, 46, 21-PostsPerPage*i+offset, 0, time.FixedZone("Asia/Tokyo", 9*60*60))
 
 		imageURLs = []string{}
@@ -116,7 +136,7 @@ func indexMoreAndMoreScenario(s *checker.Session) {
 			return
 		}
 
-		loadImages(s, imageURLs)
+		loadImages(s, "/posts", imageURLs)
 
 		if time.Since(start) > WaitAfterTimeout {
 			break
@@ -128,10 +148,12 @@ func indexMoreAndMoreScenario(s *checker.Session) {
 // WaitAfterTimeout秒たったら問答無用で打ち切る
 func loadIndexScenario(s *checker.Session) {
 	var imageURLs []string
+	var assetURLs []string
 	start := time.Now()
 
 	imagePerPageChecker := checkHTML(func(doc *goquery.Document) error {
 		imageURLs = extractImages(doc)
+		assetURLs = extractAssets(doc)
 		if len(imageURLs) < PostsPerPage {
 			return errors.New("1ページに表示される画像の数が足りません")
 		}
@@ -147,8 +169,8 @@ func loadIndexScenario(s *checker.Session) {
 		return
 	}
 
-	loadAssets(s)
-	loadImages(s, imageURLs)
+	loadAssets(s, assetURLs)
+	loadImages(s, "/", imageURLs)
 
 	for range 4 {
 		// あとの4回はDOMをパースしない。トップページをキャッシュして超高速に返されたとき対策
@@ -160,8 +182,8 @@ func loadIndexScenario(s *checker.Session) {
 			return
 		}
 
-		loadAssets(s)
-		loadImages(s, imageURLs) // 画像は初回と同じものにリクエスト投げる
+		loadAssets(s, assetURLs)
+		loadImages(s, "/", imageURLs) // 画像は初回と同じものにリクエスト投げる
 
 		if time.Since(start) > WaitAfterTimeout {
 			break
@@ -173,6 +195,7 @@ func loadIndexScenario(s *checker.Session) {
 // WaitAfterTimeout秒たったら問答無用で打ち切る
 func userAndPostPageScenario(s *checker.Session, accountName string) {
 	var imageURLs []string
+	var assetURLs []string
 	var postLinks []string
 	start := time.Now()
 
@@ -180,6 +203,7 @@ func userAndPostPageScenario(s *checker.Session, accountName string) {
 	userPage.Description = "ユーザーページ"
 	userPage.CheckFunc = checkHTML(func(doc *goquery.Document) error {
 		imageURLs = extractImages(doc)
+		assetURLs = extractAssets(doc)
 		postLinks = extractPostLinks(doc)
 		return nil
 	})
@@ -188,14 +212,15 @@ func userAndPostPageScenario(s *checker.Session, accountName string) {
 		return
 	}
 
-	loadAssets(s)
-	loadImages(s, imageURLs)
+	loadAssets(s, assetURLs)
+	loadImages(s, "/@:account_name", imageURLs)
 
 	for _, link := range postLinks {
 		postPage := checker.NewAction("GET", link)
 		postPage.Description = "投稿単体ページが表示できること"
 		postPage.CheckFunc = checkHTML(func(doc *goquery.Document) error {
 			imageURLs = extractImages(doc)
+			assetURLs = extractAssets(doc)
 			if len(imageURLs) < 1 {
 				return errors.New("投稿単体ページに投稿画像が表示されていません")
 			}
@@ -206,8 +231,8 @@ func userAndPostPageScenario(s *checker.Session, accountName string) {
 			return
 		}
 
-		loadAssets(s)
-		loadImages(s, imageURLs)
+		loadAssets(s, assetURLs)
+		loadImages(s, "/posts/:id", imageURLs)
 
 		if time.Since(start) > WaitAfterTimeout {
 			break
@@ -324,7 +349,7 @@ func postImageScenario(s *checker.Session, me user, image *checker.Asset, senten
 		return
 	}
 
-	getImage := checker.NewAssetAction(imageURLs[0], image)
+	getImage := checker.NewImageAction(imageURLs[0], image)
 	getImage.Description = "投稿した画像と一致すること"
 	getImage.Play(s)
 }
@@ -332,7 +357,7 @@ func postImageScenario(s *checker.Session, me user, image *checker.Asset, senten
 // 適当なユーザー名でログインしようとする
 // ログインできないことをチェック
 func cannotLoginNonexistentUserScenario(s *checker.Session) {
-	fakeAccountName := util.RandomLUNStr(util.RandomNumber(15) + 10)
+	fakeAccountName := s.Rand.LUNStr(s.Rand.Number(15) + 10)
 	fakeUser := map[string]string{
 		"account_name": fakeAccountName,
 		"password":     fakeAccountName,
@@ -358,7 +383,7 @@ func cannotLoginNonexistentUserScenario(s *checker.Session) {
 func cannotLoginWrongPasswordScenario(s *checker.Session, me user) {
 	fakeUser := map[string]string{
 		"account_name": me.AccountName,
-		"password":     util.RandomLUNStr(util.RandomNumber(15) + 10),
+		"password":     s.Rand.LUNStr(s.Rand.Number(15) + 10),
 	}
 
 	login := checker.NewAction("POST", "/login")
@@ -418,8 +443,8 @@ func cannotPostWrongCSRFTokenScenario(s *checker.Session, me user, image *checke
 	postImage.Description = "間違ったCSRFトークンでは画像を投稿できないこと"
 	postImage.Asset = image
 	postImage.PostData = map[string]string{
-		"body":       util.RandomLUNStr(25),
-		"csrf_token": util.RandomLUNStr(64),
+		"body":       s.Rand.LUNStr(25),
+		"csrf_token": s.Rand.LUNStr(64),
 	}
 	postImage.Play(s)
 }
@@ -428,6 +453,7 @@ func cannotPostWrongCSRFTokenScenario(s *checker.Session, me user, image *checke
 // 画像のキャッシュにSet-Cookieを含んでいた場合、/にアカウント名が含まれる
 func loginScenario(s *checker.Session, me user) {
 	var imageURLs []string
+	var assetURLs []string
 
 	login := checker.NewAction("POST", "/login")
 	login.ExpectedLocation = `^/$`
@@ -439,6 +465,7 @@ func loginScenario(s *checker.Session, me user) {
 	login.CheckFunc = checkHTML(func(doc *goquery.Document) error {
 
 		imageURLs = extractImages(doc)
+		assetURLs = extractAssets(doc)
 
 		name := doc.Find(`.isu-account-name`).Text()
 		if name == "" {
@@ -453,8 +480,8 @@ func loginScenario(s *checker.Session, me user) {
 		return
 	}
 
-	loadAssets(s)
-	loadImages(s, imageURLs) // この画像へのアクセスでSet-Cookieされてたら失敗する
+	loadAssets(s, assetURLs)
+	loadImages(s, "/", imageURLs) // この画像へのアクセスでSet-Cookieされてたら失敗する
 
 	logout := checker.NewAction("GET", "/logout")
 	logout.ExpectedLocation = `^/$`
@@ -462,6 +489,7 @@ func loginScenario(s *checker.Session, me user) {
 	logout.CheckFunc = checkHTML(func(doc *goquery.Document) error {
 
 		imageURLs = extractImages(doc)
+		assetURLs = extractAssets(doc)
 
 		name := doc.Find(`.isu-account-name`).Text()
 		if name != "" {
@@ -474,8 +502,8 @@ func loginScenario(s *checker.Session, me user) {
 		return
 	}
 
-	loadAssets(s)
-	loadImages(s, imageURLs)
+	loadAssets(s, assetURLs)
+	loadImages(s, "/", imageURLs)
 }
 
 // 新規登録→画像投稿→banされる
@@ -484,8 +512,8 @@ func banScenario(s1, s2 *checker.Session, u user, admin user, image *checker.Ass
 	var imageURLs []string
 	var userID string
 	var ok bool
-	accountName := util.RandomLUNStr(25)
-	password := util.RandomLUNStr(25)
+	accountName := s1.Rand.LUNStr(25)
+	password := s1.Rand.LUNStr(25)
 
 	register := checker.NewAction("POST", "/register")
 	register.ExpectedLocation = `^/$`
@@ -518,7 +546,7 @@ func banScenario(s1, s2 *checker.Session, u user, admin user, image *checker.Ass
 	postImage.ExpectedLocation = `^/posts/\d+$`
 	postImage.Asset = image
 	postImage.PostData = map[string]string{
-		"body":       util.RandomLUNStr(15),
+		"body":       s1.Rand.LUNStr(15),
 		"csrf_token": csrfToken,
 	}
 	postImage.CheckFunc = checkHTML(func(doc *goquery.Document) error {
@@ -528,6 +556,10 @@ func banScenario(s1, s2 *checker.Session, u user, admin user, image *checker.Ass
 		}
 		return nil
 	})
+	err = postImage.Play(s1)
+	if err != nil {
+		return
+	}
 
 	if len(imageURLs) < 1 {
 		return // このケースは上のCheckFuncの中で既にエラーにしてある
@@ -535,7 +567,7 @@ func banScenario(s1, s2 *checker.Session, u user, admin user, image *checker.Ass
 
 	imageURL := imageURLs[0]
 
-	getImage := checker.NewAssetAction(imageURL, image)
+	getImage := checker.NewImageAction(imageURL, image)
 	getImage.Description = "投稿した画像と一致することを確認"
 	err = getImage.Play(s1)
 	if err != nil {
@@ -551,7 +583,7 @@ func banScenario(s1, s2 *checker.Session, u user, admin user, image *checker.Ass
 	}
 	login.CheckFunc = checkHTML(func(doc *goquery.Document) error {
 		imageURLs = extractImages(doc)
-		if slices.Contains(imageURLs, imageURL) {
+		if containsImage(imageURLs, imageURL) {
 			return nil // 投稿した画像が正しく表示されている
 		}
 		return errors.New("投稿した画像が表示されていません")
@@ -596,7 +628,7 @@ func banScenario(s1, s2 *checker.Session, u user, admin user, image *checker.Ass
 	index.Description = "トップページに禁止ユーザーの画像が表示されていないこと"
 	index.CheckFunc = checkHTML(func(doc *goquery.Document) error {
 		imageURLs = extractImages(doc)
-		if slices.Contains(imageURLs, imageURL) {
+		if containsImage(imageURLs, imageURL) {
 			return errors.New("禁止ユーザーの画像が表示されています")
 		}
 		return nil
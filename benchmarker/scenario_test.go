@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+	"github.com/catatsuy/private-isu/benchmarker/score"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+// fakeApp は banScenario に必要なところだけ webapp と同じように振る舞う
+// 複数のホストで同じ fakeApp を使うと、DB を共有した複数台構成になる
+type fakeApp struct {
+	sync.Mutex
+	users  []string
+	banned map[int]bool
+	posts  []fakePost
+	// ignoreBan なら禁止したユーザーの画像も表示し続ける
+	ignoreBan bool
+}
+
+type fakePost struct {
+	userID int
+	data   []byte
+}
+
+func (app *fakeApp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	app.Lock()
+	defer app.Unlock()
+
+	me, _ := r.Cookie("user")
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/register":
+		app.users = append(app.users, r.FormValue("account_name"))
+		http.SetCookie(w, &http.Cookie{Name: "user", Value: r.FormValue("account_name"), Path: "/"})
+		http.Redirect(w, r, "/", http.StatusFound)
+	case r.Method == "POST" && r.URL.Path == "/login":
+		http.SetCookie(w, &http.Cookie{Name: "user", Value: r.FormValue("account_name"), Path: "/"})
+		http.Redirect(w, r, "/", http.StatusFound)
+	case r.Method == "POST" && r.URL.Path == "/":
+		f, _, err := r.FormFile("file")
+		if err != nil || me == nil || r.FormValue("csrf_token") != "token" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(f)
+		app.posts = append(app.posts, fakePost{userID: slices.Index(app.users, me.Value), data: data})
+		http.Redirect(w, r, fmt.Sprintf("/posts/%d", len(app.posts)), http.StatusFound)
+	case r.Method == "GET" && r.URL.Path == "/":
+		app.page(w, r, me, 1, len(app.posts))
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/posts/"):
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/posts/"))
+		app.page(w, r, me, id, id)
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/image/"):
+		id, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/image/"), ".png"))
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(app.posts[id-1].data)
+	case r.Method == "GET" && r.URL.Path == "/admin/banned":
+		fmt.Fprint(w, `<input name="csrf_token" value="token">`)
+		for id, name := range app.users {
+			fmt.Fprintf(w, `<input data-account-name="%s" value="%d">`, name, id)
+		}
+	case r.Method == "POST" && r.URL.Path == "/admin/banned":
+		r.ParseForm()
+		for _, uid := range r.Form["uid[]"] {
+			id, _ := strconv.Atoi(uid)
+			app.banned[id] = true
+		}
+		http.Redirect(w, r, "/admin/banned", http.StatusFound)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// page は from から to までの投稿の画像をホスト名付きの URL で出す
+func (app *fakeApp) page(w http.ResponseWriter, r *http.Request, me *http.Cookie, from, to int) {
+	if me != nil {
+		fmt.Fprintf(w, `<span class="isu-account-name">%s</span>`, me.Value)
+	}
+	fmt.Fprint(w, `<input name="csrf_token" value="token">`)
+	for id := from; id <= to; id++ {
+		if app.banned[app.posts[id-1].userID] && !app.ignoreBan {
+			continue
+		}
+		fmt.Fprintf(w, `<img class="isu-image" src="http://%s/image/%d.png">`, r.Host, id)
+	}
+}
+
+func TestBanScenarioAcrossTargets(t *testing.T) {
+	defer checker.SetTargetHost("http://localhost")
+
+	data := []byte("\x89PNG fake image")
+	path := filepath.Join(t.TempDir(), "image.png")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	image := &checker.Asset{Path: path, MD5: util.GetMD5(data), Type: "image/png"}
+	admin := user{AccountName: "admin", Password: "admin"}
+
+	for _, ignoreBan := range []bool{false, true} {
+		app := &fakeApp{banned: map[int]bool{}, ignoreBan: ignoreBan}
+		ts1 := httptest.NewServer(app)
+		ts2 := httptest.NewServer(app)
+
+		if _, err := checker.SetTargetHosts([]string{ts1.URL, ts2.URL}); err != nil {
+			t.Fatal(err)
+		}
+		s1, s2 := newSession("ban", nil), newSession("ban", nil)
+		if s1.Target == s2.Target {
+			t.Fatalf("expected sessions on different targets: %s", s1.Target.Name)
+		}
+
+		before := score.GetFailErrorsInstance().Count()
+		banScenario(s1, s2, user{}, admin, image, "")
+		errs := score.GetFailRawErrorsStringSlice()[before:]
+
+		ts1.Close()
+		ts2.Close()
+
+		if len(app.posts) != 1 || !app.banned[0] {
+			t.Fatalf("expected the post and ban to be sent: posts=%d banned=%v", len(app.posts), app.banned)
+		}
+		banned := slices.ContainsFunc(errs, func(e string) bool {
+			return strings.Contains(e, "禁止ユーザーの画像が表示されています")
+		})
+		if ignoreBan && (!banned || len(errs) != 1) {
+			t.Errorf("expected the ban check to fail: %v", errs)
+		}
+		if !ignoreBan && len(errs) != 0 {
+			t.Errorf("expected no failures: %v", errs)
+		}
+	}
+}
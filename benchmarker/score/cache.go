@@ -0,0 +1,79 @@
+package score
+
+import "sync"
+
+// CacheStats は静的ファイルのキャッシュの使われ方を数える
+type CacheStats struct {
+	sync.Mutex
+	hit         int64
+	revalidated int64
+	miss        int64
+}
+
+var cacheInstance *CacheStats
+var cacheOnce sync.Once
+
+func GetCacheInstance() *CacheStats {
+	cacheOnce.Do(func() {
+		cacheInstance = &CacheStats{}
+	})
+
+	return cacheInstance
+}
+
+// Hit はリクエストを送らずにキャッシュを使えたとき
+func (c *CacheStats) Hit() {
+	c.Lock()
+	c.hit++
+	c.Unlock()
+}
+
+// Revalidated は条件付きリクエストに304が返ってきたとき
+func (c *CacheStats) Revalidated() {
+	c.Lock()
+	c.revalidated++
+	c.Unlock()
+}
+
+// Miss はボディを取得し直したとき
+func (c *CacheStats) Miss() {
+	c.Lock()
+	c.miss++
+	c.Unlock()
+}
+
+// Merge は別のプロセスで数えた値を足し合わせる
+func (c *CacheStats) Merge(o CacheSummary) {
+	c.Lock()
+	c.hit += o.Hit
+	c.revalidated += o.Revalidated
+	c.miss += o.Miss
+	c.Unlock()
+}
+
+type CacheSummary struct {
+	Hit              int64   `json:"hit"`
+	Revalidated      int64   `json:"revalidated"`
+	Miss             int64   `json:"miss"`
+	HitRatio         float64 `json:"hit_ratio"`
+	RevalidatedRatio float64 `json:"revalidated_ratio"`
+	MissRatio        float64 `json:"miss_ratio"`
+}
+
+func (c *CacheStats) Summary() CacheSummary {
+	c.Lock()
+	defer c.Unlock()
+
+	s := CacheSummary{
+		Hit:         c.hit,
+		Revalidated: c.revalidated,
+		Miss:        c.miss,
+	}
+	total := float64(c.hit + c.revalidated + c.miss)
+	if total > 0 {
+		s.HitRatio = float64(c.hit) / total
+		s.RevalidatedRatio = float64(c.revalidated) / total
+		s.MissRatio = float64(c.miss) / total
+	}
+	return s
+}
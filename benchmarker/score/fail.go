@@ -2,7 +2,8 @@ package score
 
 import (
 	"fmt"
-	"sort"
+	"slices"
+	"strings"
 	"sync"
 )
 
@@ -24,12 +25,19 @@ func GetFailErrorsInstance() *failErrors {
 }
 
 func GetFailErrors() []error {
-	sort.Sort(GetFailErrorsInstance())
+	fes := GetFailErrorsInstance()
+	fes.RLock()
+	errs := slices.Clone(fes.errs)
+	fes.RUnlock()
+
+	slices.SortFunc(errs, func(a, b error) int {
+		return strings.Compare(a.Error(), b.Error())
+	})
 	var tmp string
 	retErrs := make([]error, 0)
 
 	// 適当にuniqする
-	for _, e := range failInstance.errs {
+	for _, e := range errs {
 		if tmp != e.Error() {
 			tmp = e.Error()
 			retErrs = append(retErrs, e)
@@ -39,7 +47,10 @@ func GetFailErrors() []error {
 }
 
 func GetFailRawErrors() []error {
-	return GetFailErrorsInstance().errs
+	fes := GetFailErrorsInstance()
+	fes.RLock()
+	defer fes.RUnlock()
+	return slices.Clone(fes.errs)
 }
 
 func GetFailErrorsStringSlice() []string {
@@ -58,16 +69,70 @@ func GetFailRawErrorsStringSlice() []string {
 	return msgs
 }
 
-func (fes failErrors) Len() int {
-	return len(fes.errs)
+// GetFailures は記録された Failure をすべて返す
+func GetFailures() []*Failure {
+	fes := GetFailErrorsInstance()
+	fes.RLock()
+	defer fes.RUnlock()
+
+	failures := make([]*Failure, 0, len(fes.errs))
+	for _, e := range fes.errs {
+		if f, ok := e.(*Failure); ok {
+			failures = append(failures, f)
+		}
+	}
+	return failures
 }
 
-func (fes failErrors) Swap(i, j int) {
-	fes.errs[i], fes.errs[j] = fes.errs[j], fes.errs[i]
+// FailureGroup の Messages は重複を除いたもの
+type FailureGroup struct {
+	Category Category `json:"category"`
+	Count    int      `json:"count"`
+	Messages []string `json:"messages"`
 }
 
-func (fes failErrors) Less(i, j int) bool {
-	return fes.errs[i].Error() < fes.errs[j].Error()
+// GetFailureGroups は失敗をカテゴリごとにまとめて件数の多い順に返す
+func GetFailureGroups() []FailureGroup {
+	groups := map[Category]*FailureGroup{}
+	seen := map[Category]map[string]bool{}
+
+	for _, e := range GetFailRawErrors() {
+		category := CategoryInternal
+		if f, ok := e.(*Failure); ok {
+			category = f.Category
+		}
+
+		g, ok := groups[category]
+		if !ok {
+			g = &FailureGroup{Category: category, Messages: []string{}}
+			groups[category] = g
+			seen[category] = map[string]bool{}
+		}
+		g.Count++
+		if !seen[category][e.Error()] {
+			seen[category][e.Error()] = true
+			g.Messages = append(g.Messages, e.Error())
+		}
+	}
+
+	ret := make([]FailureGroup, 0, len(groups))
+	for _, g := range groups {
+		slices.Sort(g.Messages)
+		ret = append(ret, *g)
+	}
+	slices.SortFunc(ret, func(a, b FailureGroup) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(string(a.Category), string(b.Category))
+	})
+	return ret
+}
+
+func (fes *failErrors) Count() int {
+	fes.RLock()
+	defer fes.RUnlock()
+	return len(fes.errs)
 }
 
 func (fes *failErrors) Append(e error) {
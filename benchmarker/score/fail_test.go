@@ -0,0 +1,30 @@
+package score
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGetFailureGroups(t *testing.T) {
+	fes := GetFailErrorsInstance()
+	fes.Append(&Failure{Category: CategoryStatus, Method: "GET", Path: "/", Message: "response code should be 200, got 500"})
+	fes.Append(&Failure{Category: CategoryStatus, Method: "GET", Path: "/", Message: "response code should be 200, got 500"})
+	fes.Append(&Failure{Category: CategoryDOM, Method: "GET", Path: "/posts", Message: "1ページに表示される画像の数が足りません"})
+	fes.Append(errors.New("unknown"))
+
+	groups := GetFailureGroups()
+	if len(groups) != 3 {
+		t.Fatalf("expected %d to eq %d", len(groups), 3)
+	}
+
+	if groups[0].Category != CategoryStatus || groups[0].Count != 2 {
+		t.Errorf("unexpected first group: %+v", groups[0])
+	}
+	if len(groups[0].Messages) != 1 || groups[0].Messages[0] != "response code should be 200, got 500 (GET /)" {
+		t.Errorf("unexpected messages: %v", groups[0].Messages)
+	}
+
+	if len(GetFailures()) != 3 {
+		t.Errorf("expected %d to eq %d", len(GetFailures()), 3)
+	}
+}
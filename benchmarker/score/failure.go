@@ -0,0 +1,40 @@
+package score
+
+import (
+	"fmt"
+	"time"
+)
+
+type Category string
+
+const (
+	CategoryTimeout    Category = "timeout"
+	CategoryConnection Category = "connection"
+	CategoryStatus     Category = "status"
+	CategoryRedirect   Category = "redirect"
+	CategoryDOM        Category = "dom"
+	CategoryAsset      Category = "asset"
+	CategoryEncoding   Category = "encoding"
+	CategoryInternal   Category = "internal"
+)
+
+// Failure は失敗したチェック1件分の記録
+type Failure struct {
+	Scenario       string    `json:"scenario"`
+	Host           string    `json:"host,omitempty"`
+	Description    string    `json:"description"`
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`
+	ExpectedStatus int       `json:"expected_status,omitempty"`
+	ActualStatus   int       `json:"actual_status,omitempty"`
+	Category       Category  `json:"category"`
+	Time           time.Time `json:"time"`
+	Message        string    `json:"message"`
+}
+
+func (f *Failure) Error() string {
+	if f.Method == "" {
+		return f.Message
+	}
+	return fmt.Sprintf("%s (%s %s)", f.Message, f.Method, f.Path)
+}
@@ -0,0 +1,59 @@
+package score
+
+import "sync"
+
+var hostInstances = make(map[string]*Score)
+var hostMu sync.Mutex
+
+// GetHostInstance はターゲットのホストごとの Score を返す
+func GetHostInstance(name string) *Score {
+	hostMu.Lock()
+	defer hostMu.Unlock()
+
+	s, ok := hostInstances[name]
+	if !ok {
+		s = &Score{}
+		hostInstances[name] = s
+	}
+	return s
+}
+
+var hostLatencyInstance *latencies
+var hostLatencyOnce sync.Once
+
+// GetHostLatencyInstance はレスポンスタイムをホストごとに記録する
+func GetHostLatencyInstance() *latencies {
+	hostLatencyOnce.Do(func() {
+		hostLatencyInstance = &latencies{
+			routes: make(map[string]*Histogram),
+		}
+	})
+
+	return hostLatencyInstance
+}
+
+// HostSummary の Score はマイナスになっていてもそのまま
+type HostSummary struct {
+	Score   int64          `json:"score"`
+	Success int64          `json:"success"`
+	Fail    int64          `json:"fail"`
+	Latency LatencySummary `json:"latency"`
+}
+
+func GetHostSummaries() map[string]HostSummary {
+	latency := GetHostLatencyInstance().Summary()
+
+	hostMu.Lock()
+	defer hostMu.Unlock()
+
+	summaries := make(map[string]HostSummary, len(hostInstances))
+	for name, s := range hostInstances {
+		summaries[name] = HostSummary{
+			Score:   s.GetRawScore(),
+			Success: s.GetSucesses(),
+			Fail:    s.GetFails(),
+			Latency: latency[name],
+		}
+	}
+	return summaries
+}
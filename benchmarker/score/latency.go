@@ -0,0 +1,219 @@
+package score
+
+import (
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// 1バケットあたり16分割の対数バケット。誤差は概ね6%以内
+const histogramSubBuckets = 16
+
+// Histogram はマイクロ秒単位の値を対数バケットで数える
+// バケットの件数を足し合わせるだけでマージできる
+type Histogram struct {
+	Counts map[int]int64 `json:"counts"`
+	Total  int64         `json:"total"`
+	Max    int64         `json:"max"`
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{Counts: make(map[int]int64)}
+}
+
+func histogramIndex(v int64) int {
+	if v < 2*histogramSubBuckets {
+		return int(v)
+	}
+	e := bits.Len64(uint64(v)) - 5
+	return (e+1)*histogramSubBuckets + int(v>>e) - histogramSubBuckets
+}
+
+// バケットに入る値の上限
+func histogramUpperBound(idx int) int64 {
+	if idx < 2*histogramSubBuckets {
+		return int64(idx)
+	}
+	e := idx/histogramSubBuckets - 1
+	m := int64(idx%histogramSubBuckets + histogramSubBuckets)
+	return (m+1)<<e - 1
+}
+
+func (h *Histogram) Add(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	h.Counts[histogramIndex(v)]++
+	h.Total++
+	if v > h.Max {
+		h.Max = v
+	}
+}
+
+func (h *Histogram) Merge(o *Histogram) {
+	for idx, c := range o.Counts {
+		h.Counts[idx] += c
+	}
+	h.Total += o.Total
+	if o.Max > h.Max {
+		h.Max = o.Max
+	}
+}
+
+// Sub は o を記録した後に増えた分だけのヒストグラムを返す
+// Maxは正確にはわからないのでバケットの上限で代用する
+func (h *Histogram) Sub(o *Histogram) *Histogram {
+	d := NewHistogram()
+	maxIdx := -1
+	for idx, c := range h.Counts {
+		if n := c - o.Counts[idx]; n > 0 {
+			d.Counts[idx] = n
+			d.Total += n
+			maxIdx = max(maxIdx, idx)
+		}
+	}
+	if maxIdx >= 0 {
+		d.Max = min(histogramUpperBound(maxIdx), h.Max)
+	}
+	return d
+}
+
+// Percentile はp(0-100)パーセンタイルが含まれるバケットの上限を返す
+func (h *Histogram) Percentile(p float64) int64 {
+	if h.Total == 0 {
+		return 0
+	}
+
+	rank := int64(float64(h.Total)*p/100 + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+
+	maxIdx := histogramIndex(h.Max)
+	var n int64
+	for idx := 0; idx <= maxIdx; idx++ {
+		n += h.Counts[idx]
+		if n >= rank {
+			return min(histogramUpperBound(idx), h.Max)
+		}
+	}
+	return h.Max
+}
+
+// LatencySummary はミリ秒単位
+type LatencySummary struct {
+	Count int64   `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+func usToMs(v int64) float64 {
+	return float64(v) / 1000
+}
+
+func (h *Histogram) Summary() LatencySummary {
+	return LatencySummary{
+		Count: h.Total,
+		P50:   usToMs(h.Percentile(50)),
+		P90:   usToMs(h.Percentile(90)),
+		P99:   usToMs(h.Percentile(99)),
+		Max:   usToMs(h.Max),
+	}
+}
+
+type latencies struct {
+	sync.Mutex
+	routes map[string]*Histogram
+}
+
+var latencyInstance *latencies
+var latencyOnce sync.Once
+
+func GetLatencyInstance() *latencies {
+	latencyOnce.Do(func() {
+		latencyInstance = &latencies{
+			routes: make(map[string]*Histogram),
+		}
+	})
+
+	return latencyInstance
+}
+
+var pageLoadInstance *latencies
+var pageLoadOnce sync.Once
+
+// GetPageLoadInstance は1ページ分の画像を読み込み終わるまでの時間をページごとに記録する
+func GetPageLoadInstance() *latencies {
+	pageLoadOnce.Do(func() {
+		pageLoadInstance = &latencies{
+			routes: make(map[string]*Histogram),
+		}
+	})
+
+	return pageLoadInstance
+}
+
+func (l *latencies) Record(route string, d time.Duration) {
+	l.Lock()
+	h, ok := l.routes[route]
+	if !ok {
+		h = NewHistogram()
+		l.routes[route] = h
+	}
+	h.Add(d.Microseconds())
+	l.Unlock()
+}
+
+// Histograms はルートごとのヒストグラムのコピーを返す
+func (l *latencies) Histograms() map[string]*Histogram {
+	l.Lock()
+	defer l.Unlock()
+
+	hs := make(map[string]*Histogram, len(l.routes))
+	for route, h := range l.routes {
+		c := NewHistogram()
+		c.Merge(h)
+		hs[route] = c
+	}
+	return hs
+}
+
+// Merge は別のプロセスで記録したヒストグラムを足し合わせる
+func (l *latencies) Merge(hs map[string]*Histogram) {
+	l.Lock()
+	defer l.Unlock()
+
+	for route, o := range hs {
+		h, ok := l.routes[route]
+		if !ok {
+			h = NewHistogram()
+			l.routes[route] = h
+		}
+		h.Merge(o)
+	}
+}
+
+// Total は全ルートをまとめたヒストグラムを返す
+func (l *latencies) Total() *Histogram {
+	l.Lock()
+	defer l.Unlock()
+
+	total := NewHistogram()
+	for _, h := range l.routes {
+		total.Merge(h)
+	}
+	return total
+}
+
+func (l *latencies) Summary() map[string]LatencySummary {
+	l.Lock()
+	defer l.Unlock()
+
+	summary := make(map[string]LatencySummary, len(l.routes))
+	for route, h := range l.routes {
+		summary[route] = h.Summary()
+	}
+	return summary
+}
@@ -0,0 +1,70 @@
+package score
+
+import "testing"
+
+func TestHistogramPercentile(t *testing.T) {
+	h := NewHistogram()
+	for i := int64(1); i <= 1000; i++ {
+		h.Add(i * 1000)
+	}
+
+	if h.Total != 1000 {
+		t.Errorf("expected %d to eq %d", h.Total, 1000)
+	}
+
+	if h.Max != 1000000 {
+		t.Errorf("expected %d to eq %d", h.Max, 1000000)
+	}
+
+	for _, tt := range []struct {
+		p        float64
+		expected int64
+	}{
+		{50, 500000},
+		{90, 900000},
+		{99, 990000},
+		{100, 1000000},
+	} {
+		got := h.Percentile(tt.p)
+		// 対数バケットなので上限は1/16まで大きくなりうる
+		if got < tt.expected || got > tt.expected+tt.expected/16 {
+			t.Errorf("p%v: expected %d to be near %d", tt.p, got, tt.expected)
+		}
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b := NewHistogram(), NewHistogram()
+	a.Add(10)
+	b.Add(20)
+	b.Add(3000)
+	a.Merge(b)
+
+	if a.Total != 3 {
+		t.Errorf("expected %d to eq %d", a.Total, 3)
+	}
+	if a.Max != 3000 {
+		t.Errorf("expected %d to eq %d", a.Max, 3000)
+	}
+	if a.Percentile(50) != 20 {
+		t.Errorf("expected %d to eq %d", a.Percentile(50), 20)
+	}
+}
+
+func TestHistogramSub(t *testing.T) {
+	h := NewHistogram()
+	h.Add(10)
+	before := NewHistogram()
+	before.Merge(h)
+
+	h.Add(5000)
+	h.Add(6000)
+
+	d := h.Sub(before)
+	if d.Total != 2 {
+		t.Errorf("expected %d to eq %d", d.Total, 2)
+	}
+	if p := d.Percentile(50); p < 5000 {
+		t.Errorf("expected %d to be >= %d", p, 5000)
+	}
+}
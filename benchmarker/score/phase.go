@@ -0,0 +1,208 @@
+package score
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+const (
+	PhaseDNS     = "dns"
+	PhaseConnect = "connect"
+	PhaseTLS     = "tls"
+	// PhaseTTFB はリクエストを送り始めてからレスポンスの最初の1バイトが届くまで
+	PhaseTTFB = "ttfb"
+)
+
+// phases は接続の段階ごとの時間をルートごとに記録する
+type phases struct {
+	sync.Mutex
+	routes map[string]map[string]*Histogram
+}
+
+var phaseInstance *phases
+var phaseOnce sync.Once
+
+func GetPhaseInstance() *phases {
+	phaseOnce.Do(func() {
+		phaseInstance = &phases{
+			routes: make(map[string]map[string]*Histogram),
+		}
+	})
+
+	return phaseInstance
+}
+
+func (p *phases) Record(route, phase string, d time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+
+	r, ok := p.routes[route]
+	if !ok {
+		r = make(map[string]*Histogram)
+		p.routes[route] = r
+	}
+	h, ok := r[phase]
+	if !ok {
+		h = NewHistogram()
+		r[phase] = h
+	}
+	h.Add(d.Microseconds())
+}
+
+// Histograms はルートと段階ごとのヒストグラムのコピーを返す
+func (p *phases) Histograms() map[string]map[string]*Histogram {
+	p.Lock()
+	defer p.Unlock()
+
+	hs := make(map[string]map[string]*Histogram, len(p.routes))
+	for route, r := range p.routes {
+		hs[route] = make(map[string]*Histogram, len(r))
+		for phase, h := range r {
+			c := NewHistogram()
+			c.Merge(h)
+			hs[route][phase] = c
+		}
+	}
+	return hs
+}
+
+// Merge は別のプロセスで記録したヒストグラムを足し合わせる
+func (p *phases) Merge(hs map[string]map[string]*Histogram) {
+	p.Lock()
+	defer p.Unlock()
+
+	for route, r := range hs {
+		if _, ok := p.routes[route]; !ok {
+			p.routes[route] = make(map[string]*Histogram)
+		}
+		for phase, o := range r {
+			h, ok := p.routes[route][phase]
+			if !ok {
+				h = NewHistogram()
+				p.routes[route][phase] = h
+			}
+			h.Merge(o)
+		}
+	}
+}
+
+func (p *phases) Summary() map[string]map[string]LatencySummary {
+	p.Lock()
+	defer p.Unlock()
+
+	summary := make(map[string]map[string]LatencySummary, len(p.routes))
+	for route, r := range p.routes {
+		s := make(map[string]LatencySummary, len(r))
+		for phase, h := range r {
+			s[phase] = h.Summary()
+		}
+		summary[route] = s
+	}
+	return summary
+}
+
+// Connection はルートごとに新しい接続と使い回した接続を数える
+type Connection struct {
+	Requests  int64            `json:"requests"`
+	New       int64            `json:"new"`
+	Reused    int64            `json:"reused"`
+	Protocols map[string]int64 `json:"protocols"`
+}
+
+func (c *Connection) Merge(o Connection) {
+	c.Requests += o.Requests
+	c.New += o.New
+	c.Reused += o.Reused
+	for proto, n := range o.Protocols {
+		c.Protocols[proto] += n
+	}
+}
+
+type ConnectionSummary struct {
+	Connection
+	ReuseRatio float64 `json:"reuse_ratio"`
+}
+
+type connections struct {
+	sync.Mutex
+	routes map[string]*Connection
+}
+
+var connectionInstance *connections
+var connectionOnce sync.Once
+
+func GetConnectionInstance() *connections {
+	connectionOnce.Do(func() {
+		connectionInstance = &connections{
+			routes: make(map[string]*Connection),
+		}
+	})
+
+	return connectionInstance
+}
+
+func (c *connections) Record(route, proto string, reused bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	r, ok := c.routes[route]
+	if !ok {
+		r = &Connection{Protocols: make(map[string]int64)}
+		c.routes[route] = r
+	}
+	r.Requests++
+	if reused {
+		r.Reused++
+	} else {
+		r.New++
+	}
+	r.Protocols[proto]++
+}
+
+func (c *connections) Routes() map[string]Connection {
+	c.Lock()
+	defer c.Unlock()
+
+	routes := make(map[string]Connection, len(c.routes))
+	for route, r := range c.routes {
+		conn := *r
+		conn.Protocols = maps.Clone(r.Protocols)
+		routes[route] = conn
+	}
+	return routes
+}
+
+// Merge は別のプロセスで数えた値を足し合わせる
+func (c *connections) Merge(routes map[string]Connection) {
+	c.Lock()
+	defer c.Unlock()
+
+	for route, o := range routes {
+		r, ok := c.routes[route]
+		if !ok {
+			r = &Connection{Protocols: make(map[string]int64)}
+			c.routes[route] = r
+		}
+		r.Merge(o)
+	}
+}
+
+func (c *connections) Summary() map[string]ConnectionSummary {
+	c.Lock()
+	defer c.Unlock()
+
+	summary := make(map[string]ConnectionSummary, len(c.routes))
+	for route, r := range c.routes {
+		s := ConnectionSummary{Connection: *r}
+		s.Protocols = make(map[string]int64, len(r.Protocols))
+		for proto, n := range r.Protocols {
+			s.Protocols[proto] = n
+		}
+		if r.Requests > 0 {
+			s.ReuseRatio = float64(r.Reused) / float64(r.Requests)
+		}
+		summary[route] = s
+	}
+	return summary
+}
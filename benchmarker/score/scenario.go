@@ -0,0 +1,41 @@
+package score
+
+import "sync"
+
+var scenarioInstances = make(map[string]*Score)
+var scenarioMu sync.Mutex
+
+// GetScenarioInstance はシナリオごとの Score を返す
+func GetScenarioInstance(name string) *Score {
+	scenarioMu.Lock()
+	defer scenarioMu.Unlock()
+
+	s, ok := scenarioInstances[name]
+	if !ok {
+		s = &Score{}
+		scenarioInstances[name] = s
+	}
+	return s
+}
+
+// ScenarioSummary の Score はマイナスになっていてもそのまま
+type ScenarioSummary struct {
+	Score   int64 `json:"score"`
+	Success int64 `json:"success"`
+	Fail    int64 `json:"fail"`
+}
+
+func GetScenarioSummaries() map[string]ScenarioSummary {
+	scenarioMu.Lock()
+	defer scenarioMu.Unlock()
+
+	summaries := make(map[string]ScenarioSummary, len(scenarioInstances))
+	for name, s := range scenarioInstances {
+		summaries[name] = ScenarioSummary{
+			Score:   s.GetRawScore(),
+			Success: s.GetSucesses(),
+			Fail:    s.GetFails(),
+		}
+	}
+	return summaries
+}
@@ -35,6 +35,14 @@ func (s *Score) GetScore() int64 {
 	return score
 }
 
+// GetRawScore はマイナスになっていてもそのまま返す
+func (s *Score) GetRawScore() int64 {
+	s.RLock()
+	score := s.score
+	s.RUnlock()
+	return score
+}
+
 func (s *Score) GetSucesses() int64 {
 	s.RLock()
 	sucesses := s.sucesses
@@ -56,6 +64,15 @@ func (s *Score) SetScore(point int64) {
 	s.Unlock()
 }
 
+// Merge は別のプロセスで集計した値を足し合わせる
+func (s *Score) Merge(score, sucesses, fails int64) {
+	s.Lock()
+	s.score += score
+	s.sucesses += sucesses
+	s.fails += fails
+	s.Unlock()
+}
+
 func (s *Score) SetFails(point int64) {
 	s.Lock()
 	s.score -= point
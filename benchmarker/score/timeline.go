@@ -0,0 +1,161 @@
+package score
+
+import (
+	"sync"
+	"time"
+)
+
+// TimelineBucket は一定間隔ごとの集計。InFlightはその間の同時リクエスト数の最大値
+type TimelineBucket struct {
+	Time     float64 `json:"t"`
+	Score    int64   `json:"score"`
+	Success  int64   `json:"success"`
+	Fail     int64   `json:"fail"`
+	InFlight int64   `json:"in_flight"`
+}
+
+type timeline struct {
+	sync.Mutex
+	start    time.Time
+	interval time.Duration
+	buckets  []TimelineBucket
+	inFlight int64
+	emitted  int
+}
+
+var timelineInstance *timeline
+var timelineOnce sync.Once
+
+func GetTimelineInstance() *timeline {
+	timelineOnce.Do(func() {
+		timelineInstance = &timeline{}
+	})
+
+	return timelineInstance
+}
+
+// Start を呼ぶまでは何も記録しない
+func (t *timeline) Start(interval time.Duration) {
+	t.Lock()
+	t.start = time.Now()
+	t.interval = interval
+	t.buckets = nil
+	t.emitted = 0
+	t.Unlock()
+}
+
+// ロックを取った状態で呼ぶこと
+func (t *timeline) current() *TimelineBucket {
+	if t.interval <= 0 {
+		return nil
+	}
+
+	idx := int(time.Since(t.start) / t.interval)
+	for len(t.buckets) <= idx {
+		t.buckets = append(t.buckets, TimelineBucket{
+			Time:     (time.Duration(len(t.buckets)) * t.interval).Seconds(),
+			InFlight: t.inFlight,
+		})
+	}
+	return &t.buckets[idx]
+}
+
+func (t *timeline) AddSuccess(point int64) {
+	t.Lock()
+	if b := t.current(); b != nil {
+		b.Score += point
+		b.Success++
+	}
+	t.Unlock()
+}
+
+func (t *timeline) AddFail(point int64) {
+	t.Lock()
+	if b := t.current(); b != nil {
+		b.Score -= point
+		b.Fail++
+	}
+	t.Unlock()
+}
+
+func (t *timeline) IncInFlight() {
+	t.Lock()
+	t.inFlight++
+	if b := t.current(); b != nil && b.InFlight < t.inFlight {
+		b.InFlight = t.inFlight
+	}
+	t.Unlock()
+}
+
+func (t *timeline) DecInFlight() {
+	t.Lock()
+	t.inFlight--
+	t.Unlock()
+}
+
+// MergeAt は start から記録された別のプロセスのバケットを足し合わせる
+// InFlight はプロセスごとの最大値の合計になる
+func (t *timeline) MergeAt(start time.Time, buckets []TimelineBucket) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.interval <= 0 {
+		return
+	}
+
+	offset := start.Sub(t.start)
+	for _, b := range buckets {
+		at := offset + time.Duration(b.Time*float64(time.Second))
+		idx := max(int(at/t.interval), 0)
+		for len(t.buckets) <= idx {
+			t.buckets = append(t.buckets, TimelineBucket{
+				Time: (time.Duration(len(t.buckets)) * t.interval).Seconds(),
+			})
+		}
+		t.buckets[idx].Score += b.Score
+		t.buckets[idx].Success += b.Success
+		t.buckets[idx].Fail += b.Fail
+		t.buckets[idx].InFlight += b.InFlight
+	}
+}
+
+// StartedAt は Start を呼んだ時刻
+func (t *timeline) StartedAt() time.Time {
+	t.Lock()
+	defer t.Unlock()
+	return t.start
+}
+
+func (t *timeline) Buckets() []TimelineBucket {
+	t.Lock()
+	defer t.Unlock()
+
+	t.current()
+	buckets := make([]TimelineBucket, len(t.buckets))
+	copy(buckets, t.buckets)
+	return buckets
+}
+
+// Closed はまだ返していない集計済みのバケットを返す
+// all が true なら集計中のバケットも含める
+func (t *timeline) Closed(all bool) []TimelineBucket {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.current() == nil {
+		return nil
+	}
+
+	end := len(t.buckets) - 1
+	if all {
+		end = len(t.buckets)
+	}
+	if end <= t.emitted {
+		return nil
+	}
+
+	buckets := make([]TimelineBucket, end-t.emitted)
+	copy(buckets, t.buckets[t.emitted:end])
+	t.emitted = end
+	return buckets
+}
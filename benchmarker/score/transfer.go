@@ -0,0 +1,100 @@
+package score
+
+import "sync"
+
+// Transfer はルートごとの転送量。Wire は圧縮されたまま、Decoded は展開した後のバイト数
+type Transfer struct {
+	Requests     int64 `json:"requests"`
+	Compressed   int64 `json:"compressed"`
+	WireBytes    int64 `json:"wire_bytes"`
+	DecodedBytes int64 `json:"decoded_bytes"`
+}
+
+func (t *Transfer) Merge(o Transfer) {
+	t.Requests += o.Requests
+	t.Compressed += o.Compressed
+	t.WireBytes += o.WireBytes
+	t.DecodedBytes += o.DecodedBytes
+}
+
+// TransferSummary の Ratio は展開後に対する転送量の割合。小さいほどよく圧縮できている
+type TransferSummary struct {
+	Transfer
+	Ratio float64 `json:"ratio"`
+}
+
+type transfers struct {
+	sync.Mutex
+	routes map[string]*Transfer
+}
+
+var transferInstance *transfers
+var transferOnce sync.Once
+
+func GetTransferInstance() *transfers {
+	transferOnce.Do(func() {
+		transferInstance = &transfers{
+			routes: make(map[string]*Transfer),
+		}
+	})
+
+	return transferInstance
+}
+
+func (t *transfers) Record(route string, wire, decoded int64, compressed bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	r, ok := t.routes[route]
+	if !ok {
+		r = &Transfer{}
+		t.routes[route] = r
+	}
+	r.Requests++
+	if compressed {
+		r.Compressed++
+	}
+	r.WireBytes += wire
+	r.DecodedBytes += decoded
+}
+
+func (t *transfers) Routes() map[string]Transfer {
+	t.Lock()
+	defer t.Unlock()
+
+	routes := make(map[string]Transfer, len(t.routes))
+	for route, r := range t.routes {
+		routes[route] = *r
+	}
+	return routes
+}
+
+// Merge は別のプロセスで数えた値を足し合わせる
+func (t *transfers) Merge(routes map[string]Transfer) {
+	t.Lock()
+	defer t.Unlock()
+
+	for route, o := range routes {
+		r, ok := t.routes[route]
+		if !ok {
+			r = &Transfer{}
+			t.routes[route] = r
+		}
+		r.Merge(o)
+	}
+}
+
+func (t *transfers) Summary() map[string]TransferSummary {
+	t.Lock()
+	defer t.Unlock()
+
+	summary := make(map[string]TransferSummary, len(t.routes))
+	for route, r := range t.routes {
+		s := TransferSummary{Transfer: *r}
+		if r.DecodedBytes > 0 {
+			s.Ratio = float64(r.WireBytes) / float64(r.DecodedBytes)
+		}
+		summary[route] = s
+	}
+	return summary
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/score"
+)
+
+const (
+	LoadModeConstant = "constant"
+	LoadModeLinear   = "linear"
+	LoadModeStep     = "step"
+	LoadModeSpike    = "spike"
+)
+
+// loadStage の間は各シナリオの並列数を Multiplier 倍にする
+type loadStage struct {
+	Multiplier float64
+	Duration   time.Duration
+}
+
+type loadStageOptions struct {
+	Mode            string        `json:"mode"`
+	StageInterval   time.Duration `json:"stage_interval"`
+	StepMultiplier  float64       `json:"step_multiplier"`
+	SpikeMultiplier float64       `json:"spike_multiplier"`
+	SpikeDuration   time.Duration `json:"spike_duration"`
+}
+
+// buildLoadStages は total の時間を負荷のかけ方に応じて区切る
+//
+//	constant: 最初から最後まで1倍
+//	linear:   StageInterval ごとに 1/n 倍から1倍まで少しずつ上げる
+//	step:     StageInterval ごとに StepMultiplier ずつ上げる
+//	spike:    1倍で動かしている途中の SpikeDuration だけ SpikeMultiplier 倍にする
+func buildLoadStages(total time.Duration, opts loadStageOptions) ([]loadStage, error) {
+	switch opts.Mode {
+	case LoadModeConstant, "":
+		return []loadStage{{Multiplier: 1, Duration: total}}, nil
+	case LoadModeLinear, LoadModeStep:
+		if opts.StageInterval <= 0 {
+			return nil, fmt.Errorf("stage-interval must be positive")
+		}
+		n := int((total + opts.StageInterval - 1) / opts.StageInterval)
+		stages := make([]loadStage, 0, n)
+		for i := range n {
+			d := min(opts.StageInterval, total-time.Duration(i)*opts.StageInterval)
+			m := float64(i+1) / float64(n)
+			if opts.Mode == LoadModeStep {
+				m = 1 + float64(i)*opts.StepMultiplier
+			}
+			stages = append(stages, loadStage{Multiplier: m, Duration: d})
+		}
+		return stages, nil
+	case LoadModeSpike:
+		if opts.SpikeDuration <= 0 || opts.SpikeDuration >= total {
+			return nil, fmt.Errorf("spike-duration must be between 0 and benchmark-timeout")
+		}
+		before := (total - opts.SpikeDuration) / 2
+		return []loadStage{
+			{Multiplier: 1, Duration: before},
+			{Multiplier: opts.SpikeMultiplier, Duration: opts.SpikeDuration},
+			{Multiplier: 1, Duration: total - opts.SpikeDuration - before},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown load mode: %s", opts.Mode)
+}
+
+// StageResult の Score などはそのステージの間に増えた分
+type StageResult struct {
+	Stage       int     `json:"stage"`
+	Start       float64 `json:"start"`
+	Duration    float64 `json:"duration"`
+	Multiplier  float64 `json:"multiplier"`
+	Concurrency int     `json:"concurrency"`
+	Score       int64   `json:"score"`
+	Success     int64   `json:"success"`
+	Fail        int64   `json:"fail"`
+	ErrorRate   float64 `json:"error_rate"`
+}
+
+type scoreSnapshot struct {
+	score, successes, fails int64
+}
+
+func takeScoreSnapshot() scoreSnapshot {
+	s := score.GetInstance()
+	return scoreSnapshot{
+		score:     s.GetRawScore(),
+		successes: s.GetSucesses(),
+		fails:     s.GetFails(),
+	}
+}
+
+func errorRate(successes, fails int64) float64 {
+	if successes+fails == 0 {
+		return 0
+	}
+	return float64(fails) / float64(successes+fails)
+}
+
+// runLoadStages はステージごとにシナリオの並列数を変えながら回す
+func runLoadStages(pools []*scenarioPool, profile concurrencyProfile, stages []loadStage) []StageResult {
+	results := make([]StageResult, 0, len(stages))
+	start := time.Now()
+
+	for i, stage := range stages {
+		before := takeScoreSnapshot()
+		stageStart := time.Since(start)
+
+		concurrency := resizePools(pools, profile.Scale(stage.Multiplier))
+		time.Sleep(stage.Duration)
+
+		after := takeScoreSnapshot()
+		success := after.successes - before.successes
+		fail := after.fails - before.fails
+		results = append(results, StageResult{
+			Stage:       i + 1,
+			Start:       stageStart.Seconds(),
+			Duration:    stage.Duration.Seconds(),
+			Multiplier:  stage.Multiplier,
+			Concurrency: concurrency,
+			Score:       after.score - before.score,
+			Success:     success,
+			Fail:        fail,
+			ErrorRate:   errorRate(success, fail),
+		})
+	}
+
+	stopPools(pools)
+
+	return results
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildLoadStages(t *testing.T) {
+	stages, err := buildLoadStages(25*time.Second, loadStageOptions{Mode: LoadModeStep, StageInterval: 10 * time.Second, StepMultiplier: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stages) != 3 {
+		t.Fatalf("expected %d to eq %d", len(stages), 3)
+	}
+	if stages[2].Multiplier != 2 {
+		t.Errorf("expected %v to eq %v", stages[2].Multiplier, 2)
+	}
+	if stages[2].Duration != 5*time.Second {
+		t.Errorf("expected %v to eq %v", stages[2].Duration, 5*time.Second)
+	}
+
+	stages, err = buildLoadStages(60*time.Second, loadStageOptions{Mode: LoadModeSpike, SpikeMultiplier: 5, SpikeDuration: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stages) != 3 || stages[1].Multiplier != 5 || stages[0].Duration != 25*time.Second {
+		t.Errorf("unexpected spike stages: %+v", stages)
+	}
+
+	if _, err := buildLoadStages(60*time.Second, loadStageOptions{Mode: "unknown"}); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
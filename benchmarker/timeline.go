@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/score"
+)
+
+// タイムラインの集計が終わったバケットをNDJSONで書き出していく
+// 返り値の関数を呼ぶと残りを書き出して終了する
+func startTimelineWriter(path string, errStream io.Writer, interval time.Duration) (func(), error) {
+	var w io.Writer
+	var closer io.Closer
+
+	if path == "-" {
+		w = errStream
+	} else {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+		closer = f
+	}
+
+	enc := json.NewEncoder(w)
+	write := func(all bool) {
+		for _, b := range score.GetTimelineInstance().Closed(all) {
+			enc.Encode(b)
+		}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				write(false)
+			case <-done:
+				write(true)
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+		if closer != nil {
+			closer.Close()
+		}
+	}, nil
+}
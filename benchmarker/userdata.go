@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -90,3 +91,19 @@ func prepareUserdata(userdata string) ([]user, []user, []user, []string, []*chec
 
 	return users[9:], bannedUsers, adminUsers, sentences, images, err
 }
+
+// 投稿に使う画像をデコードしておく。-image-verify=decode か -image-accept を指定したときだけ使う
+func prepareImageFingerprints(images []*checker.Asset) error {
+	for _, img := range images {
+		f, err := os.Open(img.Path)
+		if err != nil {
+			return err
+		}
+		img.Image, err = checker.NewImageFingerprint(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", img.Path, err)
+		}
+	}
+	return nil
+}
@@ -3,8 +3,10 @@ package util
 import (
 	"crypto/md5"
 	"fmt"
+	"hash/fnv"
 	"io"
 	mrand "math/rand/v2"
+	"sync"
 )
 
 func GetMD5(data []byte) string {
@@ -38,3 +40,38 @@ func randomStr(n int, s []rune) string {
 	}
 	return string(buf)
 }
+
+// Rand はシードを固定できる乱数。nil のときはグローバルな乱数を使う
+type Rand struct {
+	mu sync.Mutex
+	r  *mrand.Rand
+}
+
+// NewRand は seed と name と index の組み合わせごとに独立した乱数列を作る
+func NewRand(seed uint64, name string, index int) *Rand {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return &Rand{r: mrand.New(mrand.NewPCG(seed, h.Sum64()+uint64(index)))}
+}
+
+func (r *Rand) Number(max int) int {
+	if r == nil {
+		return RandomNumber(max)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.IntN(max)
+}
+
+func (r *Rand) LUNStr(n int) string {
+	if r == nil {
+		return RandomLUNStr(n)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	buf := make([]byte, n)
+	for i := range n {
+		buf[i] = byte(lunRunes[r.r.IntN(len(lunRunes))])
+	}
+	return string(buf)
+}
@@ -0,0 +1,22 @@
+package util
+
+import "testing"
+
+func TestNewRand(t *testing.T) {
+	a := NewRand(42, "login", 1)
+	b := NewRand(42, "login", 1)
+	c := NewRand(42, "login", 2)
+
+	sa, sb, sc := a.LUNStr(32), b.LUNStr(32), c.LUNStr(32)
+	if sa != sb {
+		t.Errorf("expected %q to eq %q", sa, sb)
+	}
+	if sa == sc {
+		t.Errorf("expected %q not to eq %q", sa, sc)
+	}
+
+	var r *Rand
+	if n := r.Number(10); n < 0 || n >= 10 {
+		t.Errorf("expected %d to be in [0, 10)", n)
+	}
+}